@@ -1,19 +1,95 @@
 package lkdr
 
-import "context"
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrReauthRequired is returned when interactive authorization is required, but not possible,
+// either because no Authorizer is set or because it is disabled with NonInteractive mode.
+var ErrReauthRequired = errors.New("interactive reauthorization required")
 
 type Authorizer interface {
 	GetCaptchaToken(ctx context.Context, userAgent, siteKey, pageURL string) (string, error)
 	GetConfirmationCode(ctx context.Context, phone string) (string, error)
 }
 
-type authorizerKey struct{}
+// BadCaptchaReporter may be implemented by Authorizer to be notified
+// about captcha tokens rejected by the server, e.g. in order to claim a refund.
+type BadCaptchaReporter interface {
+	ReportBadCaptcha(ctx context.Context, captchaToken string) error
+}
+
+// CaptchaSolver solves Yandex SmartCaptcha required to start SMS challenge.
+type CaptchaSolver interface {
+	SolveCaptcha(ctx context.Context, userAgent, siteKey, pageURL string) (string, error)
+}
+
+// CaptchaSolverFunc is a functional CaptchaSolver adapter.
+type CaptchaSolverFunc func(ctx context.Context, userAgent, siteKey, pageURL string) (string, error)
+
+func (fn CaptchaSolverFunc) SolveCaptcha(ctx context.Context, userAgent, siteKey, pageURL string) (string, error) {
+	return fn(ctx, userAgent, siteKey, pageURL)
+}
+
+// ConfirmationCodeProvider provides SMS code sent to the phone.
+type ConfirmationCodeProvider interface {
+	GetConfirmationCode(ctx context.Context, phone string) (string, error)
+}
+
+// ConfirmationCodeFunc is a functional ConfirmationCodeProvider adapter.
+type ConfirmationCodeFunc func(ctx context.Context, phone string) (string, error)
+
+func (fn ConfirmationCodeFunc) GetConfirmationCode(ctx context.Context, phone string) (string, error) {
+	return fn(ctx, phone)
+}
+
+// CompositeAuthorizer combines CaptchaSolver and ConfirmationCodeProvider into Authorizer.
+// Bad captcha reports are passed to CaptchaSolver if it implements BadCaptchaReporter.
+type CompositeAuthorizer struct {
+	CaptchaSolver            CaptchaSolver
+	ConfirmationCodeProvider ConfirmationCodeProvider
+}
+
+func (a CompositeAuthorizer) GetCaptchaToken(ctx context.Context, userAgent, siteKey, pageURL string) (string, error) {
+	return a.CaptchaSolver.SolveCaptcha(ctx, userAgent, siteKey, pageURL)
+}
+
+func (a CompositeAuthorizer) GetConfirmationCode(ctx context.Context, phone string) (string, error) {
+	return a.ConfirmationCodeProvider.GetConfirmationCode(ctx, phone)
+}
+
+func (a CompositeAuthorizer) ReportBadCaptcha(ctx context.Context, captchaToken string) error {
+	if reporter, ok := a.CaptchaSolver.(BadCaptchaReporter); ok {
+		return reporter.ReportBadCaptcha(ctx, captchaToken)
+	}
 
+	return nil
+}
+
+type (
+	authorizerKey     struct{}
+	nonInteractiveKey struct{}
+)
+
+// WithAuthorizer overrides ClientParams.Authorizer for calls made with the returned context.
 func WithAuthorizer(ctx context.Context, authorizer Authorizer) context.Context {
 	return context.WithValue(ctx, authorizerKey{}, authorizer)
 }
 
+// WithNonInteractive disables interactive authorization for calls made with the returned context.
+// Calls requiring it fail with ErrReauthRequired.
+func WithNonInteractive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nonInteractiveKey{}, true)
+}
+
 func getAuthorizer(ctx context.Context) Authorizer {
 	authorizer, _ := ctx.Value(authorizerKey{}).(Authorizer)
 	return authorizer
 }
+
+func isNonInteractive(ctx context.Context) bool {
+	nonInteractive, _ := ctx.Value(nonInteractiveKey{}).(bool)
+	return nonInteractive
+}
@@ -0,0 +1,77 @@
+package captcha
+
+import (
+	"context"
+	stderrors "errors"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+// Link is a CaptchaSolver in Chain.
+type Link struct {
+	Solver lkdr.CaptchaSolver
+
+	// Timeout limits the time spent by the solver. Zero means no limit.
+	Timeout time.Duration
+}
+
+// Chain tries solvers in order until one of them succeeds.
+// Bad captcha reports are passed to the solver which produced the token.
+type Chain struct {
+	links   []Link
+	solvers recentTokens[lkdr.CaptchaSolver]
+}
+
+func NewChain(links ...Link) *Chain {
+	return &Chain{links: links}
+}
+
+func (c *Chain) SolveCaptcha(ctx context.Context, userAgent, siteKey, pageURL string) (string, error) {
+	var errs []error
+	for i, link := range c.links {
+		token, err := c.solve(ctx, link, userAgent, siteKey, pageURL)
+		if err == nil {
+			c.solvers.put(token, link.Solver)
+			return token, nil
+		}
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		errs = append(errs, errors.Wrapf(err, "solver %d", i))
+	}
+
+	if len(errs) == 0 {
+		return "", errors.New("no solvers configured")
+	}
+
+	return "", errors.Wrap(stderrors.Join(errs...), "all solvers failed")
+}
+
+func (c *Chain) ReportBadCaptcha(ctx context.Context, captchaToken string) error {
+	solver, ok := c.solvers.take(captchaToken)
+	if reporter, isReporter := solver.(lkdr.BadCaptchaReporter); ok && isReporter {
+		return reporter.ReportBadCaptcha(ctx, captchaToken)
+	}
+
+	return nil
+}
+
+func (c *Chain) solve(ctx context.Context, link Link, userAgent, siteKey, pageURL string) (string, error) {
+	if link.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, link.Timeout)
+		defer cancel()
+	}
+
+	token, err := link.Solver.SolveCaptcha(ctx, userAgent, siteKey, pageURL)
+	if err == nil && token == "" {
+		err = errors.New("empty token")
+	}
+
+	return token, err
+}
@@ -0,0 +1,107 @@
+package captcha
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Manual asks a human to solve captcha in a browser and enter the resulting token.
+// Manual must not be copied after first use. Only one line is read from In at a time:
+// if a prompt expires before the token is entered, the pending read is reused by the next prompt
+// and a line entered in between is discarded as stale.
+type Manual struct {
+	// In is read for captcha token. Defaults to os.Stdin.
+	In io.Reader
+
+	// Out is used to print instructions. Defaults to os.Stdout.
+	Out io.Writer
+
+	reader  *bufio.Reader
+	pending chan line
+	sem     chan struct{}
+	once    sync.Once
+}
+
+type line struct {
+	text string
+	err  error
+}
+
+func (s *Manual) SolveCaptcha(ctx context.Context, userAgent, siteKey, pageURL string) (string, error) {
+	s.once.Do(s.init)
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	out := s.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	s.discardStale()
+	if _, err := fmt.Fprintf(out, "Open %s, solve captcha (site key %s) and enter the token: ", pageURL, siteKey); err != nil {
+		return "", errors.Wrap(err, "print instructions")
+	}
+
+	return s.readLine(ctx)
+}
+
+func (s *Manual) init() {
+	in := s.In
+	if in == nil {
+		in = os.Stdin
+	}
+
+	s.reader = bufio.NewReader(in)
+	s.sem = make(chan struct{}, 1)
+}
+
+// discardStale drops the line entered after the previous prompt expired.
+func (s *Manual) discardStale() {
+	if s.pending == nil {
+		return
+	}
+
+	select {
+	case <-s.pending:
+		s.pending = nil
+	default:
+	}
+}
+
+// readLine reads a single line, returning early if the context is done.
+// The read itself is left pending for the next call in the latter case.
+func (s *Manual) readLine(ctx context.Context) (string, error) {
+	if s.pending == nil {
+		pending := make(chan line, 1)
+		go func() {
+			text, err := s.reader.ReadString('\n')
+			if err != nil && !(errors.Is(err, io.EOF) && text != "") {
+				pending <- line{err: errors.Wrap(err, "read line")}
+				return
+			}
+
+			pending <- line{text: strings.TrimSpace(text)}
+		}()
+
+		s.pending = pending
+	}
+
+	select {
+	case l := <-s.pending:
+		s.pending = nil
+		return l.text, l.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,40 @@
+package captcha
+
+import "sync"
+
+// maxRecentTokens limits the number of solved tokens remembered for bad captcha reports.
+// Tokens are reported right after the server rejects them, so only the recent ones are needed.
+const maxRecentTokens = 64
+
+// recentTokens maps the most recently solved tokens to values, forgetting the oldest ones.
+// The zero value is ready to use.
+type recentTokens[V any] struct {
+	values map[string]V
+	order  []string
+	mu     sync.Mutex
+}
+
+func (r *recentTokens[V]) put(token string, value V) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values == nil {
+		r.values = make(map[string]V)
+	}
+
+	if len(r.order) >= maxRecentTokens {
+		delete(r.values, r.order[0])
+		r.order = r.order[1:]
+	}
+
+	r.values[token] = value
+	r.order = append(r.order, token)
+}
+
+// take returns the value for the token and forgets it.
+func (r *recentTokens[V]) take(token string) (V, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value, ok := r.values[token]
+	delete(r.values, token)
+	return value, ok
+}
@@ -0,0 +1,43 @@
+// Package captcha provides lkdr.CaptchaSolver implementations.
+package captcha
+
+import (
+	"context"
+
+	"github.com/jfk9w-go/rucaptcha-api"
+)
+
+// Rucaptcha solves captcha with RuCaptcha service.
+// Rejected solutions are reported back, so that the spent funds are refunded.
+type Rucaptcha struct {
+	client *rucaptcha.Client
+	ids    recentTokens[string]
+}
+
+func NewRucaptcha(client *rucaptcha.Client) *Rucaptcha {
+	return &Rucaptcha{client: client}
+}
+
+func (s *Rucaptcha) SolveCaptcha(ctx context.Context, userAgent, siteKey, pageURL string) (string, error) {
+	solved, err := s.client.Solve(ctx, &rucaptcha.YandexSmartCaptchaIn{
+		UserAgent: userAgent,
+		SiteKey:   siteKey,
+		PageURL:   pageURL,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	s.ids.put(solved.Answer, solved.ID)
+	return solved.Answer, nil
+}
+
+func (s *Rucaptcha) ReportBadCaptcha(ctx context.Context, captchaToken string) error {
+	id, ok := s.ids.take(captchaToken)
+	if !ok {
+		return nil
+	}
+
+	return s.client.Report(ctx, id, false)
+}
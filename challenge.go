@@ -0,0 +1,41 @@
+package lkdr
+
+import (
+	"context"
+	"sync"
+)
+
+// ChallengeStorage may be implemented by TokenStorage in order to persist active SMS challenge,
+// so that verification of an already sent SMS code can be resumed after restart.
+// Challenges are kept in memory otherwise.
+type ChallengeStorage interface {
+	LoadChallenge(ctx context.Context, phone string) (*Challenge, error)
+	UpdateChallenge(ctx context.Context, phone string, challenge *Challenge) error
+}
+
+type memoryChallengeStorage struct {
+	challenges map[string]*Challenge
+	mu         sync.Mutex
+}
+
+func (s *memoryChallengeStorage) LoadChallenge(_ context.Context, phone string) (*Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.challenges[phone], nil
+}
+
+func (s *memoryChallengeStorage) UpdateChallenge(_ context.Context, phone string, challenge *Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.challenges == nil {
+		s.challenges = make(map[string]*Challenge)
+	}
+
+	if challenge != nil {
+		s.challenges[phone] = challenge
+	} else {
+		delete(s.challenges, phone)
+	}
+
+	return nil
+}
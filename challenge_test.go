@@ -0,0 +1,40 @@
+package lkdr_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+func TestClient_Challenge(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	storage := newMemoryStorage()
+	authorizer := &lkdrtest.Authorizer{Server: server, Fails: 1}
+
+	t.Run("keeps challenge if code is not received", func(t *testing.T) {
+		_, err := newClient(t, server, storage, authorizer).Receipt(ctx, &lkdr.ReceiptIn{})
+		assert.Error(t, err)
+
+		challenge, err := storage.LoadChallenge(ctx, phone)
+		require.NoError(t, err)
+		assert.NotNil(t, challenge)
+	})
+
+	t.Run("resumes persisted challenge", func(t *testing.T) {
+		_, err := newClient(t, server, storage, authorizer).Receipt(ctx, &lkdr.ReceiptIn{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, server.Requests(lkdrtest.StartPath))
+
+		challenge, err := storage.LoadChallenge(ctx, phone)
+		require.NoError(t, err)
+		assert.Nil(t, challenge)
+	})
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jfk9w-go/based"
@@ -12,17 +15,29 @@ import (
 )
 
 const (
-	baseURL           = "https://mco.nalog.ru/api"
+	DefaultBaseURL    = "https://mco.nalog.ru/api"
 	expireTokenOffset = 5 * time.Minute
 	captchaSiteKey    = "hfU4TD7fJUI7XcP5qRphKWgnIR5t9gXAxTRqdQJk"
 	captchaPageURL    = "https://lkdr.nalog.ru/login"
+
+	defaultCaptchaAttempts = 3
 )
 
+// ErrTokensConflict may be returned by TokenStorage.UpdateTokens if tokens were updated concurrently
+// since they were last loaded. Client then adopts the stored tokens instead of its own.
+var ErrTokensConflict = errors.New("tokens were updated concurrently")
+
 type TokenStorage interface {
 	LoadTokens(ctx context.Context, phone string) (*Tokens, error)
 	UpdateTokens(ctx context.Context, phone string, tokens *Tokens) error
 }
 
+// TokenLocker coordinates token renewal between processes sharing one TokenStorage.
+// LockTokens blocks until the lock is acquired or the context is done. The returned function releases the lock.
+type TokenLocker interface {
+	LockTokens(ctx context.Context, phone string) (func(), error)
+}
+
 type ClientParams struct {
 	Phone        string       `validate:"required"`
 	Clock        based.Clock  `validate:"required"`
@@ -30,7 +45,25 @@ type ClientParams struct {
 	UserAgent    string       `validate:"required"`
 	TokenStorage TokenStorage `validate:"required"`
 
-	Transport http.RoundTripper
+	Transport   http.RoundTripper
+	BaseURL     string
+	RetryPolicy *RetryPolicy
+
+	// Authorizer is used for interactive authorization unless overridden with WithAuthorizer.
+	Authorizer Authorizer
+
+	// NonInteractive disables interactive authorization: requests requiring it fail with ErrReauthRequired.
+	// This is useful for background jobs which should alert instead of waiting for SMS code.
+	NonInteractive bool
+
+	// TokenLocker is used to guard token renewal. TokenStorage is used if it implements TokenLocker.
+	TokenLocker TokenLocker
+
+	// CaptchaAttempts limits the number of captcha solutions tried when the server responds with BlockedCaptcha.
+	CaptchaAttempts int
+
+	// RateLimiter limits all requests, including authorization ones. Defaults to TokenBucket with DefaultBudget.
+	RateLimiter RateLimiter
 }
 
 func NewClient(params ClientParams) (*Client, error) {
@@ -38,9 +71,47 @@ func NewClient(params ClientParams) (*Client, error) {
 		return nil, err
 	}
 
+	captchaAttempts := params.CaptchaAttempts
+	if captchaAttempts <= 0 {
+		captchaAttempts = defaultCaptchaAttempts
+	}
+
+	tokenLocker := params.TokenLocker
+	if tokenLocker == nil {
+		tokenLocker, _ = params.TokenStorage.(TokenLocker)
+	}
+
+	challenges, ok := params.TokenStorage.(ChallengeStorage)
+	if !ok {
+		challenges = new(memoryChallengeStorage)
+	}
+
+	rateLimiter := params.RateLimiter
+	if rateLimiter == nil {
+		var err error
+		rateLimiter, err = NewTokenBucket(TokenBucketParams{
+			Clock: params.Clock,
+			Total: DefaultBudget,
+		})
+
+		if err != nil {
+			return nil, errors.Wrap(err, "create rate limiter")
+		}
+	}
+
+	baseURL := params.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
 	return &Client{
-		clock: params.Clock,
-		phone: params.Phone,
+		clock:             params.Clock,
+		phone:             params.Phone,
+		baseURL:           strings.TrimRight(baseURL, "/"),
+		retryPolicy:       params.RetryPolicy,
+		captchaAttempts:   captchaAttempts,
+		defaultAuthorizer: params.Authorizer,
+		nonInteractive:    params.NonInteractive,
 		deviceInfo: deviceInfo{
 			SourceType:     "WEB",
 			SourceDeviceId: params.DeviceID,
@@ -52,24 +123,33 @@ func NewClient(params ClientParams) (*Client, error) {
 		httpClient: &http.Client{
 			Transport: params.Transport,
 		},
-		token: based.NewWriteThroughCached[string, *Tokens](
-			based.WriteThroughCacheStorageFunc[string, *Tokens]{
-				LoadFn:   params.TokenStorage.LoadTokens,
-				UpdateFn: params.TokenStorage.UpdateTokens,
-			},
-			params.Phone,
-		),
-		mu: based.Semaphore(params.Clock, 20, time.Minute),
+		tokenStorage: params.TokenStorage,
+		tokenLocker:  tokenLocker,
+		tokens: &tokenCache{
+			storage: params.TokenStorage,
+			phone:   params.Phone,
+		},
+		challenges:  challenges,
+		rateLimiter: rateLimiter,
 	}, nil
 }
 
 type Client struct {
-	clock      based.Clock
-	phone      string
-	deviceInfo deviceInfo
-	httpClient *http.Client
-	token      *based.WriteThroughCached[*Tokens]
-	mu         based.Locker
+	clock             based.Clock
+	phone             string
+	baseURL           string
+	retryPolicy       *RetryPolicy
+	captchaAttempts   int
+	defaultAuthorizer Authorizer
+	nonInteractive    bool
+	deviceInfo        deviceInfo
+	httpClient        *http.Client
+	tokenStorage      TokenStorage
+	tokens            *tokenCache
+	tokenLocker       TokenLocker
+	tokenMu           based.RWMutex
+	challenges        ChallengeStorage
+	rateLimiter       RateLimiter
 }
 
 func (c *Client) Receipt(ctx context.Context, in *ReceiptIn) (*ReceiptOut, error) {
@@ -80,82 +160,180 @@ func (c *Client) FiscalData(ctx context.Context, in *FiscalDataIn) (*FiscalDataO
 	return execute(ctx, c, in)
 }
 
-func (c *Client) ensureToken(ctx context.Context) (string, error) {
-	tokens, err := c.token.Get(ctx)
+type tokenRenewal int
+
+const (
+	renewNone tokenRenewal = iota
+	renewRefresh
+	renewAuthorize
+)
+
+func (c *Client) tokenRenewal(tokens *Tokens, rejected string) tokenRenewal {
+	expireAt := c.clock.Now().Add(expireTokenOffset)
+	switch {
+	case tokens == nil || tokens.RefreshTokenExpiresIn != nil && tokens.RefreshTokenExpiresIn.Time().Before(expireAt):
+		return renewAuthorize
+	case rejected != "" && tokens.Token == rejected || tokens.TokenExpireIn.Time().Before(expireAt):
+		return renewRefresh
+	default:
+		return renewNone
+	}
+}
+
+// ensureToken returns a valid access token, refreshing or re-authorizing if needed.
+// If rejected is not empty and matches the current access token, the token is refreshed regardless of its expiry time.
+// Renewal is performed under TokenLocker, if set, after re-reading tokens which may have been renewed by another process.
+func (c *Client) ensureToken(ctx context.Context, rejected string) (string, error) {
+	ctx, cancel := c.tokenMu.Lock(ctx)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	tokens, err := c.tokens.get(ctx)
 	if err != nil {
 		return "", errors.Wrap(err, "load token")
 	}
 
-	now := c.clock.Now()
-	updateToken := true
-	if tokens == nil || tokens.RefreshTokenExpiresIn != nil && tokens.RefreshTokenExpiresIn.Time().Before(now.Add(expireTokenOffset)) {
+	if c.tokenRenewal(tokens, rejected) == renewNone {
+		return tokens.Token, nil
+	}
+
+	tokens, unlock, err := c.lockTokens(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	defer unlock()
+
+	switch c.tokenRenewal(tokens, rejected) {
+	case renewNone:
+		return tokens.Token, nil
+	case renewAuthorize:
 		tokens, err = c.authorize(ctx)
 		if err != nil {
 			return "", errors.Wrap(err, "authorize")
 		}
-	} else if tokens.TokenExpireIn.Time().Before(now.Add(expireTokenOffset)) {
+	case renewRefresh:
 		tokens, err = c.refreshToken(ctx, tokens.RefreshToken)
-		if err != nil {
+		if IsAuthError(err) {
+			tokens, err = c.updateTokens(ctx, nil)
+			if err != nil {
+				return "", errors.Wrap(err, "invalidate token")
+			}
+
+			if tokens != nil {
+				return tokens.Token, nil
+			}
+
+			tokens, err = c.authorize(ctx)
+			if err != nil {
+				return "", errors.Wrap(err, "authorize")
+			}
+		} else if err != nil {
 			return "", errors.Wrap(err, "refresh token")
 		}
-	} else {
-		updateToken = false
 	}
 
-	if updateToken {
-		if err := c.token.Update(ctx, tokens); err != nil {
-			return "", errors.Wrap(err, "update token")
-		}
+	tokens, err = c.updateTokens(ctx, tokens)
+	if err != nil {
+		return "", errors.Wrap(err, "update token")
+	}
+
+	if tokens == nil {
+		return "", errors.New("tokens were removed concurrently")
 	}
 
 	return tokens.Token, nil
 }
 
-func (c *Client) authorize(ctx context.Context) (*Tokens, error) {
-	authorizer := getAuthorizer(ctx)
-	if authorizer == nil {
-		return nil, errors.New("authorizer is required, but not set")
-	}
+// lockTokens acquires TokenLocker, if set, and reloads tokens which may have been renewed by another process.
+// Without TokenLocker cached tokens are returned. The returned function releases the lock.
+// Must be called under tokenMu.
+func (c *Client) lockTokens(ctx context.Context) (*Tokens, func(), error) {
+	if c.tokenLocker == nil {
+		tokens, err := c.tokens.get(ctx)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "load token")
+		}
 
-	captchaToken, err := authorizer.GetCaptchaToken(ctx, c.deviceInfo.MetaDetails.UserAgent, captchaSiteKey, captchaPageURL)
-	if err != nil {
-		return nil, errors.Wrap(err, "get captcha token")
+		return tokens, func() {}, nil
 	}
 
-	startIn := &startIn{
-		DeviceInfo:   c.deviceInfo,
-		Phone:        c.phone,
-		CaptchaToken: captchaToken,
+	unlock, err := c.tokenLocker.LockTokens(ctx, c.phone)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "lock tokens")
 	}
 
-	startOut, err := execute(ctx, c, startIn)
+	tokens, err := c.tokens.reload(ctx)
 	if err != nil {
-		var clientErr Error
-		if !errors.As(err, &clientErr) || clientErr.Code != SmsVerificationNotExpired {
-			return nil, errors.Wrap(err, "start sms challenge")
-		}
+		unlock()
+		return nil, nil, errors.Wrap(err, "reload token")
 	}
 
-	code, err := authorizer.GetConfirmationCode(ctx, c.phone)
-	if err != nil {
-		return nil, errors.Wrap(err, "get confirmation code")
+	return tokens, unlock, nil
+}
+
+// updateTokens persists tokens. If the storage reports ErrTokensConflict,
+// tokens updated concurrently by someone else are reloaded and returned instead.
+// Must be called under tokenMu.
+func (c *Client) updateTokens(ctx context.Context, tokens *Tokens) (*Tokens, error) {
+	err := c.tokenStorage.UpdateTokens(ctx, c.phone, tokens)
+	if errors.Is(err, ErrTokensConflict) {
+		stored, err := c.tokens.reload(ctx)
+		return stored, errors.Wrap(err, "reload tokens")
+	} else if err != nil {
+		return nil, err
 	}
 
-	verifyIn := &verifyIn{
-		DeviceInfo:     c.deviceInfo,
-		Phone:          c.phone,
-		ChallengeToken: startOut.ChallengeToken,
-		Code:           code,
+	c.tokens.set(tokens)
+	return tokens, nil
+}
+
+// tokenCache holds tokens last read from or written to TokenStorage.
+// It never writes to the storage itself, so that adopting tokens renewed elsewhere does not count as an update.
+type tokenCache struct {
+	storage TokenStorage
+	phone   string
+	tokens  *Tokens
+	loaded  bool
+	mu      sync.Mutex
+}
+
+// get returns cached tokens, loading them from storage on first use.
+func (c *tokenCache) get(ctx context.Context) (*Tokens, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded {
+		return c.tokens, nil
 	}
 
-	tokens, err := execute(ctx, c, verifyIn)
+	return c.load(ctx)
+}
+
+// reload replaces cached tokens with the stored ones.
+func (c *tokenCache) reload(ctx context.Context) (*Tokens, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.load(ctx)
+}
+
+func (c *tokenCache) load(ctx context.Context) (*Tokens, error) {
+	tokens, err := c.storage.LoadTokens(ctx, c.phone)
 	if err != nil {
-		return nil, errors.Wrap(err, "verify code")
+		return nil, err
 	}
 
+	c.tokens, c.loaded = tokens, true
 	return tokens, nil
 }
 
+func (c *tokenCache) set(tokens *Tokens) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens, c.loaded = tokens, true
+}
+
 func (c *Client) refreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
 	in := &tokenIn{
 		DeviceInfo:   c.deviceInfo,
@@ -165,32 +343,75 @@ func (c *Client) refreshToken(ctx context.Context, refreshToken string) (*Tokens
 	return execute[Tokens](ctx, c, in)
 }
 
+// execute performs the request, authorizing it if required.
+// Authorized requests rejected by the server with 401 are replayed once with a renewed token.
 func execute[R any](ctx context.Context, c *Client, in exchange[R]) (*R, error) {
-	var token string
-	if in.auth() {
-		var (
-			cancel context.CancelFunc
-			err    error
-		)
-
-		ctx, cancel = c.mu.Lock(ctx)
-		defer cancel()
-		if err := ctx.Err(); err != nil {
+	if !in.auth() {
+		return do(ctx, c, in, "")
+	}
+
+	token, err := c.ensureToken(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := do(ctx, c, in, token)
+	if !IsAuthError(err) {
+		return out, err
+	}
+
+	token, err = c.ensureToken(ctx, token)
+	if err != nil {
+		return nil, errors.Wrap(err, "renew rejected token")
+	}
+
+	return do(ctx, c, in, token)
+}
+
+// do performs the request, retrying it according to RetryPolicy.
+// Only data requests are retried: authorization requests are not idempotent, since a refresh which reached
+// the server rotates the refresh token, and a repeated challenge start sends another SMS.
+// Backoff delays are slept with real timers regardless of Clock.
+func do[R any](ctx context.Context, c *Client, in exchange[R], token string) (*R, error) {
+	retryPolicy := c.retryPolicy
+	if !in.auth() {
+		retryPolicy = nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := c.rateLimiter.Wait(ctx, in.path()); err != nil {
 			return nil, err
 		}
 
-		token, err = c.ensureToken(ctx)
-		if err != nil {
+		out, err := roundTrip(ctx, c, in, token)
+		if err == nil || ctx.Err() != nil {
+			return out, err
+		}
+
+		reportRateLimited(c.rateLimiter, c.clock, in.path(), err)
+
+		delay, ok := retryPolicy.delay(c.clock, attempt, err)
+		if !ok {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
 			return nil, err
 		}
 	}
+}
 
+func roundTrip[R any](ctx context.Context, c *Client, in exchange[R], token string) (*R, error) {
 	reqBody, err := json.Marshal(in)
 	if err != nil {
 		return nil, errors.Wrap(err, "marshal json body")
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+in.path(), bytes.NewReader(reqBody))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+in.path(), bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, errors.Wrap(err, "create request")
 	}
@@ -205,25 +426,23 @@ func execute[R any](ctx context.Context, c *Client, in exchange[R]) (*R, error)
 		return nil, errors.Wrap(err, "execute request")
 	}
 
-	if httpResp.Body == nil {
-		return nil, errors.New(httpResp.Status)
+	if httpResp.Body != nil {
+		defer httpResp.Body.Close()
 	}
 
-	defer httpResp.Body.Close()
-
 	if httpResp.StatusCode != http.StatusOK {
-		var clientErr Error
-		if err := json.NewDecoder(httpResp.Body).Decode(&clientErr); err == nil {
-			return nil, clientErr
-		}
-
-		return nil, errors.New(httpResp.Status)
+		return nil, newHTTPError(in.path(), httpResp)
 	}
 
 	var out R
-	if err := json.NewDecoder(httpResp.Body).Decode(&out); err != nil {
+	if err := json.NewDecoder(httpResp.Body).Decode(&out); err != nil && !(errors.Is(err, io.EOF) && isUnit(out)) {
 		return nil, errors.Wrap(err, "decode response body")
 	}
 
 	return &out, nil
 }
+
+func isUnit(value any) bool {
+	_, ok := value.(based.Unit)
+	return ok
+}
@@ -0,0 +1,170 @@
+package lkdr_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+const phone = "79999999999"
+
+type memoryStorage struct {
+	tokens     map[string]*lkdr.Tokens
+	challenges map[string]*lkdr.Challenge
+	mu         sync.Mutex
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		tokens:     make(map[string]*lkdr.Tokens),
+		challenges: make(map[string]*lkdr.Challenge),
+	}
+}
+
+func (s *memoryStorage) LoadTokens(_ context.Context, phone string) (*lkdr.Tokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[phone], nil
+}
+
+func (s *memoryStorage) UpdateTokens(_ context.Context, phone string, tokens *lkdr.Tokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[phone] = tokens
+	return nil
+}
+
+func (s *memoryStorage) LoadChallenge(_ context.Context, phone string) (*lkdr.Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.challenges[phone], nil
+}
+
+func (s *memoryStorage) UpdateChallenge(_ context.Context, phone string, challenge *lkdr.Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.challenges[phone] = challenge
+	return nil
+}
+
+func newClient(t *testing.T, server *lkdrtest.Server, storage lkdr.TokenStorage, authorizer lkdr.Authorizer) *lkdr.Client {
+	params := lkdr.ClientParams{
+		Phone:        phone,
+		Clock:        based.StandardClock,
+		DeviceID:     "device",
+		UserAgent:    "test",
+		TokenStorage: storage,
+		BaseURL:      server.URL(),
+		Authorizer:   authorizer,
+		RateLimiter:  lkdr.RateLimiterFunc(func(context.Context, string) error { return nil }),
+	}
+
+	if authorizer == nil {
+		params.NonInteractive = true
+	}
+
+	client, err := lkdr.NewClient(params)
+	require.NoError(t, err)
+	return client
+}
+
+func TestClient_Authorize(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	server.AddReceipts(phone, lkdr.Receipt{Key: "key"})
+	storage := newMemoryStorage()
+	client := newClient(t, server, storage, &lkdrtest.Authorizer{Server: server})
+
+	for range 2 {
+		out, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+		require.NoError(t, err)
+		require.Len(t, out.Receipts, 1)
+		assert.Equal(t, "key", out.Receipts[0].Key)
+	}
+
+	assert.Equal(t, 1, server.Requests(lkdrtest.StartPath))
+	assert.Equal(t, 1, server.Requests(lkdrtest.VerifyPath))
+
+	tokens, err := storage.LoadTokens(ctx, phone)
+	require.NoError(t, err)
+	require.NotNil(t, tokens)
+	assert.NotEmpty(t, tokens.Token)
+}
+
+func TestClient_Receipts(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	now := time.Now()
+	brandID := int64(1)
+	server.AddBrands(lkdr.Brand{Id: brandID, Name: "brand"})
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		receipt := lkdr.Receipt{Key: key, ReceiveDate: lkdr.DateTime(now.Add(-time.Duration(i) * time.Minute))}
+		if i%2 == 0 {
+			receipt.BrandId = &brandID
+		}
+
+		server.AddReceipts(phone, receipt)
+	}
+
+	storage := newMemoryStorage()
+	require.NoError(t, storage.UpdateTokens(ctx, phone, server.IssueTokens(phone)))
+	client := newClient(t, server, storage, nil)
+
+	var keys []string
+	for receipt, err := range client.Receipts(ctx, &lkdr.ReceiptIn{Limit: 2, OrderBy: "RECEIVE_DATE:DESC"}) {
+		require.NoError(t, err)
+		keys = append(keys, receipt.Key)
+		if receipt.BrandId != nil {
+			require.NotNil(t, receipt.Brand, receipt.Key)
+			assert.Equal(t, "brand", receipt.Brand.Name)
+		} else {
+			assert.Nil(t, receipt.Brand, receipt.Key)
+		}
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+	assert.Equal(t, 3, server.Requests(lkdrtest.ReceiptPath))
+}
+
+func TestClient_Unauthorized(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	storage := newMemoryStorage()
+	issued := server.IssueTokens(phone)
+	require.NoError(t, storage.UpdateTokens(ctx, phone, issued))
+	client := newClient(t, server, storage, nil)
+
+	t.Run("refreshes rejected token and replays request", func(t *testing.T) {
+		server.ExpireTokens(phone)
+		_, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, server.Requests(lkdrtest.ReceiptPath))
+		assert.Equal(t, 1, server.Requests(lkdrtest.TokenPath))
+
+		tokens, err := storage.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.NotEqual(t, issued.Token, tokens.Token)
+		assert.NotEqual(t, issued.RefreshToken, tokens.RefreshToken)
+	})
+
+	t.Run("requires authorization if refresh token is revoked", func(t *testing.T) {
+		server.RevokeTokens(phone)
+		_, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+		assert.ErrorIs(t, err, lkdr.ErrReauthRequired)
+		assert.Equal(t, 2, server.Requests(lkdrtest.TokenPath))
+	})
+}
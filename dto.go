@@ -3,7 +3,7 @@ package lkdr
 import (
 	"context"
 	"encoding/json"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/jfk9w-go/based"
@@ -153,42 +153,6 @@ func (dt *DateTimeMilliOffset) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-type ErrorCode string
-
-const (
-	SmsVerificationNotExpired ErrorCode = "registration.sms.verification.not.expired"
-	BlockedCaptcha            ErrorCode = "blocked.captcha"
-	ReceiptFiscalDataNotFound ErrorCode = "receipt.fiscaldata.not.found.dr"
-)
-
-type Error struct {
-	Code    ErrorCode `json:"code"`
-	Message string    `json:"message"`
-}
-
-func (e Error) Error() string {
-	var b strings.Builder
-	if e.Code != "" {
-		b.WriteString(string(e.Code))
-		if e.Message != "" {
-			b.WriteString(" (" + e.Message + ")")
-		}
-	} else if e.Message != "" {
-		b.WriteString(e.Message)
-	}
-
-	return b.String()
-}
-
-func IsDataNotFound(err error) bool {
-	var e Error
-	if errors.As(err, &e); e.Code == ReceiptFiscalDataNotFound {
-		return true
-	}
-
-	return false
-}
-
 type metaDetails struct {
 	UserAgent string `json:"userAgent"`
 }
@@ -222,6 +186,12 @@ type startOut struct {
 	ChallengeTokenExpiresInSec int                 `json:"challengeTokenExpiresInSec"`
 }
 
+// Challenge is an active SMS challenge.
+type Challenge struct {
+	Token     string              `json:"challengeToken"`
+	ExpiresIn DateTimeMilliOffset `json:"challengeTokenExpiresIn"`
+}
+
 type verifyIn struct {
 	DeviceInfo     deviceInfo `json:"deviceInfo"`
 	Phone          string     `json:"phone" validate:"required"`
@@ -282,12 +252,38 @@ type Receipt struct {
 	KktOwnerInn          string   `json:"kktOwnerInn"`
 	ReceiveDate          DateTime `json:"receiveDate"`
 	TotalSum             string   `json:"totalSum"`
+
+	// Raw is the original JSON object including fields unknown to this package. It is not marshaled.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (r *Receipt) UnmarshalJSON(data []byte) error {
+	type plain Receipt
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	r.Raw = slices.Clone(data)
+	return nil
 }
 
 type ReceiptOut struct {
 	Brands   []Brand   `json:"brands"`
 	Receipts []Receipt `json:"receipts"`
 	HasMore  bool      `json:"hasMore"`
+
+	// Raw is the original response body. It is not marshaled.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (out *ReceiptOut) UnmarshalJSON(data []byte) error {
+	type plain ReceiptOut
+	if err := json.Unmarshal(data, (*plain)(out)); err != nil {
+		return err
+	}
+
+	out.Raw = slices.Clone(data)
+	return nil
 }
 
 type FiscalDataIn struct {
@@ -343,4 +339,18 @@ type FiscalDataOut struct {
 	TotalSum                float64          `json:"totalSum"`
 	User                    *string          `json:"user"`
 	UserInn                 string           `json:"userInn"`
+
+	// Raw is the original response body including fields unknown to this package
+	// (e.g. newer fiscal document format tags). It is not marshaled.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (out *FiscalDataOut) UnmarshalJSON(data []byte) error {
+	type plain FiscalDataOut
+	if err := json.Unmarshal(data, (*plain)(out)); err != nil {
+		return err
+	}
+
+	out.Raw = slices.Clone(data)
+	return nil
 }
@@ -0,0 +1,98 @@
+package lkdr_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+
+	brandID := int64(7)
+	receiveDate := lkdr.DateTime(time.Date(2024, 5, 1, 12, 30, 0, 0, moscow))
+	receipt := lkdr.Receipt{
+		BrandId:     &brandID,
+		Key:         "key",
+		KktOwner:    "owner",
+		ReceiveDate: receiveDate,
+		CreatedDate: receiveDate,
+		TotalSum:    "100.50",
+	}
+
+	brand := &lkdr.Brand{Id: brandID, Name: "brand"}
+	fiscalData := &lkdr.FiscalDataOut{DateTime: receiveDate, TotalSum: 100.5, KktRegId: "kkt", Items: []lkdr.FiscalDataItem{{Name: "item"}}}
+	receiptWithBrand := lkdr.ReceiptWithBrand{Receipt: receipt, Brand: brand}
+
+	t.Run("receipt keeps unknown fields in raw", func(t *testing.T) {
+		data := []byte(`{"key":"key","totalSum":"1.00","unknown":{"nested":true}}`)
+		var decoded lkdr.Receipt
+		require.NoError(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, "key", decoded.Key)
+		assert.Equal(t, "1.00", decoded.TotalSum)
+		assert.JSONEq(t, string(data), string(decoded.Raw))
+	})
+
+	t.Run("fiscal data keeps unknown fields in raw", func(t *testing.T) {
+		data := []byte(`{"totalSum":5,"kktRegId":"kkt","newTag":4}`)
+		var decoded lkdr.FiscalDataOut
+		require.NoError(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, 5.0, decoded.TotalSum)
+		assert.Equal(t, "kkt", decoded.KktRegId)
+		assert.JSONEq(t, string(data), string(decoded.Raw))
+	})
+
+	t.Run("receipt", func(t *testing.T) {
+		assertRoundTrip(t, receipt, func(value *lkdr.Receipt) { value.Raw = nil })
+	})
+
+	t.Run("receipt out", func(t *testing.T) {
+		out := lkdr.ReceiptOut{Brands: []lkdr.Brand{*brand}, Receipts: []lkdr.Receipt{receipt}, HasMore: true}
+		assertRoundTrip(t, out, func(value *lkdr.ReceiptOut) {
+			value.Raw = nil
+			for i := range value.Receipts {
+				value.Receipts[i].Raw = nil
+			}
+		})
+	})
+
+	t.Run("fiscal data", func(t *testing.T) {
+		assertRoundTrip(t, *fiscalData, func(value *lkdr.FiscalDataOut) { value.Raw = nil })
+	})
+
+	t.Run("receipt with brand", func(t *testing.T) {
+		assertRoundTrip(t, receiptWithBrand, func(value *lkdr.ReceiptWithBrand) { value.Raw = nil })
+	})
+
+	t.Run("synced receipt", func(t *testing.T) {
+		synced := lkdr.SyncedReceipt{ReceiptWithBrand: receiptWithBrand, FiscalData: fiscalData}
+		assertRoundTrip(t, synced, func(value *lkdr.SyncedReceipt) {
+			value.Raw = nil
+			if value.FiscalData != nil {
+				value.FiscalData.Raw = nil
+			}
+		})
+	})
+
+	t.Run("account receipt", func(t *testing.T) {
+		account := lkdr.AccountReceipt{Phone: "79999999999", ReceiptWithBrand: receiptWithBrand}
+		assertRoundTrip(t, account, func(value *lkdr.AccountReceipt) { value.Raw = nil })
+	})
+}
+
+// assertRoundTrip marshals and unmarshals the value, checking that nothing but raw JSON is changed.
+func assertRoundTrip[T any](t *testing.T, value T, clearRaw func(value *T)) {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+
+	var decoded T
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	clearRaw(&decoded)
+	assert.Equal(t, value, decoded)
+}
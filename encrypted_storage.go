@@ -0,0 +1,238 @@
+package lkdr
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const sealedTokensPrefix = "lkdr-sealed-v1"
+
+// ErrTokensTampered is returned when sealed tokens fail authentication.
+var ErrTokensTampered = errors.New("sealed tokens are tampered or sealed with another key")
+
+// KeyProvider provides AES keys (16, 24 or 32 bytes long) for EncryptedTokenStorage.
+type KeyProvider interface {
+	// CurrentKey returns the key used to seal tokens along with its ID.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the key with given ID used to open previously sealed tokens.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// KeyRing is a static KeyProvider.
+// Rotation is performed by adding a new key and switching Current to it; old keys should be kept
+// until all stored tokens are re-sealed (see EncryptedTokenStorage.Rotate).
+type KeyRing struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (r KeyRing) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := r.Key(ctx, r.Current)
+	return r.Current, key, err
+}
+
+func (r KeyRing) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok {
+		return nil, errors.Errorf("key %s not found", id)
+	}
+
+	return key, nil
+}
+
+// EncryptedTokenStorage is a TokenStorage decorator which seals Tokens with AES-GCM
+// before passing them to the underlying storage.
+//
+// Sealed tokens are stored in Tokens.Token as "lkdr-sealed-v1.<key ID>.<base64 payload>",
+// all other fields are left empty. Phone is used as additional authenticated data,
+// so sealed tokens can not be moved between phones.
+// Loading never writes to the underlying storage: tokens are sealed with the current key on next update
+// or by Rotate.
+type EncryptedTokenStorage struct {
+	Storage TokenStorage
+	Keys    KeyProvider
+
+	// AllowPlaintext enables loading of tokens stored before encryption was enabled.
+	// Such tokens are sealed on next update or by Rotate.
+	AllowPlaintext bool
+
+	challenges memoryChallengeStorage
+}
+
+func (s *EncryptedTokenStorage) LoadTokens(ctx context.Context, phone string) (*Tokens, error) {
+	tokens, _, err := s.load(ctx, phone)
+	return tokens, err
+}
+
+// Rotate re-seals stored tokens with the current key, or seals plaintext tokens if AllowPlaintext is set.
+// It does nothing if tokens are already sealed with the current key.
+// Tokens are also re-sealed whenever they are updated, so calling Rotate is only needed
+// to retire old keys sooner.
+func (s *EncryptedTokenStorage) Rotate(ctx context.Context, phone string) error {
+	tokens, keyID, err := s.load(ctx, phone)
+	if err != nil || tokens == nil {
+		return err
+	}
+
+	currentKeyID, _, err := s.Keys.CurrentKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get current key")
+	}
+
+	if keyID == currentKeyID {
+		return nil
+	}
+
+	return s.UpdateTokens(ctx, phone, tokens)
+}
+
+// load returns stored tokens along with the ID of the key they are sealed with (empty for plaintext tokens).
+func (s *EncryptedTokenStorage) load(ctx context.Context, phone string) (*Tokens, string, error) {
+	stored, err := s.Storage.LoadTokens(ctx, phone)
+	if err != nil || stored == nil {
+		return stored, "", err
+	}
+
+	keyID, payload, ok := parseSealedTokens(stored.Token)
+	if !ok {
+		if !s.AllowPlaintext {
+			return nil, "", errors.New("stored tokens are not sealed")
+		}
+
+		return stored, "", nil
+	}
+
+	key, err := s.Keys.Key(ctx, keyID)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "get key")
+	}
+
+	data, err := aesOpen(key, payload, []byte(phone))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var tokens Tokens
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, "", errors.Wrap(err, "decode tokens")
+	}
+
+	return &tokens, keyID, nil
+}
+
+func (s *EncryptedTokenStorage) UpdateTokens(ctx context.Context, phone string, tokens *Tokens) error {
+	if tokens == nil {
+		return s.Storage.UpdateTokens(ctx, phone, nil)
+	}
+
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return errors.Wrap(err, "encode tokens")
+	}
+
+	keyID, key, err := s.Keys.CurrentKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get current key")
+	}
+
+	if strings.Contains(keyID, ".") {
+		return errors.Errorf("key ID %s must not contain dots", keyID)
+	}
+
+	payload, err := aesSeal(key, data, []byte(phone))
+	if err != nil {
+		return err
+	}
+
+	sealed := &Tokens{Token: sealedTokensPrefix + "." + keyID + "." + payload}
+	return s.Storage.UpdateTokens(ctx, phone, sealed)
+}
+
+// LoadChallenge delegates to the underlying storage if it implements ChallengeStorage.
+// Challenges are kept in memory otherwise.
+func (s *EncryptedTokenStorage) LoadChallenge(ctx context.Context, phone string) (*Challenge, error) {
+	if challenges, ok := s.Storage.(ChallengeStorage); ok {
+		return challenges.LoadChallenge(ctx, phone)
+	}
+
+	return s.challenges.LoadChallenge(ctx, phone)
+}
+
+// UpdateChallenge delegates to the underlying storage if it implements ChallengeStorage.
+// Challenges are kept in memory otherwise.
+func (s *EncryptedTokenStorage) UpdateChallenge(ctx context.Context, phone string, challenge *Challenge) error {
+	if challenges, ok := s.Storage.(ChallengeStorage); ok {
+		return challenges.UpdateChallenge(ctx, phone, challenge)
+	}
+
+	return s.challenges.UpdateChallenge(ctx, phone, challenge)
+}
+
+// LockTokens delegates to the underlying storage if it implements TokenLocker and does nothing otherwise.
+func (s *EncryptedTokenStorage) LockTokens(ctx context.Context, phone string) (func(), error) {
+	if locker, ok := s.Storage.(TokenLocker); ok {
+		return locker.LockTokens(ctx, phone)
+	}
+
+	return func() {}, nil
+}
+
+func parseSealedTokens(value string) (keyID, payload string, ok bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] != sealedTokensPrefix {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "create cipher")
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func aesSeal(key, data, additionalData []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "generate nonce")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, data, additionalData)), nil
+}
+
+func aesOpen(key []byte, payload string, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, ErrTokensTampered
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	data, err = gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrTokensTampered
+	}
+
+	return data, nil
+}
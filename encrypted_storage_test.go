@@ -0,0 +1,167 @@
+package lkdr_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+func TestEncryptedTokenStorage(t *testing.T) {
+	ctx := context.Background()
+	tokens := &lkdr.Tokens{Token: "token", RefreshToken: "refresh"}
+	keys := lkdr.KeyRing{
+		Current: "k1",
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 32),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+
+	newStorage := func() (*lkdr.EncryptedTokenStorage, *memoryStorage) {
+		underlying := newMemoryStorage()
+		return &lkdr.EncryptedTokenStorage{Storage: underlying, Keys: keys}, underlying
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		storage, underlying := newStorage()
+		require.NoError(t, storage.UpdateTokens(ctx, phone, tokens))
+
+		sealed, err := underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(sealed.Token, "lkdr-sealed-v1.k1."), sealed.Token)
+		assert.NotContains(t, sealed.Token, tokens.Token)
+		assert.Empty(t, sealed.RefreshToken)
+
+		loaded, err := storage.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, tokens, loaded)
+	})
+
+	t.Run("nil tokens", func(t *testing.T) {
+		storage, underlying := newStorage()
+		require.NoError(t, storage.UpdateTokens(ctx, phone, tokens))
+		require.NoError(t, storage.UpdateTokens(ctx, phone, nil))
+
+		sealed, err := underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Nil(t, sealed)
+
+		loaded, err := storage.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Nil(t, loaded)
+	})
+
+	t.Run("tampered payload", func(t *testing.T) {
+		storage, underlying := newStorage()
+		require.NoError(t, storage.UpdateTokens(ctx, phone, tokens))
+
+		sealed, err := underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		i := strings.LastIndex(sealed.Token, ".") + 1
+		payload, err := base64.RawURLEncoding.DecodeString(sealed.Token[i:])
+		require.NoError(t, err)
+		payload[len(payload)-1] ^= 1
+		tampered := &lkdr.Tokens{Token: sealed.Token[:i] + base64.RawURLEncoding.EncodeToString(payload)}
+		require.NoError(t, underlying.UpdateTokens(ctx, phone, tampered))
+
+		_, err = storage.LoadTokens(ctx, phone)
+		assert.ErrorIs(t, err, lkdr.ErrTokensTampered)
+	})
+
+	t.Run("moved to another phone", func(t *testing.T) {
+		storage, underlying := newStorage()
+		require.NoError(t, storage.UpdateTokens(ctx, phone, tokens))
+
+		sealed, err := underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		require.NoError(t, underlying.UpdateTokens(ctx, "78888888888", sealed))
+
+		_, err = storage.LoadTokens(ctx, "78888888888")
+		assert.ErrorIs(t, err, lkdr.ErrTokensTampered)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		storage, underlying := newStorage()
+		require.NoError(t, storage.UpdateTokens(ctx, phone, tokens))
+
+		storage = &lkdr.EncryptedTokenStorage{
+			Storage: underlying,
+			Keys:    lkdr.KeyRing{Current: "k2", Keys: map[string][]byte{"k2": keys.Keys["k2"]}},
+		}
+
+		_, err := storage.LoadTokens(ctx, phone)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, lkdr.ErrTokensTampered)
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		storage, underlying := newStorage()
+		require.NoError(t, storage.UpdateTokens(ctx, phone, tokens))
+
+		rotated := keys
+		rotated.Current = "k2"
+		storage.Keys = rotated
+
+		loaded, err := storage.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, tokens, loaded, "tokens sealed with old key are loaded")
+
+		sealed, err := underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(sealed.Token, "lkdr-sealed-v1.k1."), "load does not re-seal")
+
+		require.NoError(t, storage.Rotate(ctx, phone))
+		sealed, err = underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(sealed.Token, "lkdr-sealed-v1.k2."), sealed.Token)
+
+		storage.Keys = lkdr.KeyRing{Current: "k2", Keys: map[string][]byte{"k2": keys.Keys["k2"]}}
+		loaded, err = storage.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, tokens, loaded, "old key may be retired after rotation")
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		storage, underlying := newStorage()
+		require.NoError(t, underlying.UpdateTokens(ctx, phone, tokens))
+
+		_, err := storage.LoadTokens(ctx, phone)
+		assert.Error(t, err, "plaintext is rejected by default")
+
+		storage.AllowPlaintext = true
+		loaded, err := storage.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, tokens, loaded)
+
+		stored, err := underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, tokens, stored, "load does not seal plaintext tokens")
+
+		require.NoError(t, storage.Rotate(ctx, phone))
+		stored, err = underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(stored.Token, "lkdr-sealed-v1.k1."), stored.Token)
+
+		storage.AllowPlaintext = false
+		loaded, err = storage.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, tokens, loaded)
+	})
+
+	t.Run("key ID with dots", func(t *testing.T) {
+		storage, underlying := newStorage()
+		storage.Keys = lkdr.KeyRing{Current: "k.1", Keys: map[string][]byte{"k.1": keys.Keys["k1"]}}
+		assert.Error(t, storage.UpdateTokens(ctx, phone, tokens))
+
+		stored, err := underlying.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Nil(t, stored, "nothing is written")
+	})
+}
@@ -0,0 +1,158 @@
+package lkdr
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type ErrorCode string
+
+// ErrChallengeExpired is returned when SMS code is verified after the challenge has expired.
+// It is detected by the client clock before sending the code, since the server response is not known to tell
+// an expired challenge from an invalid code.
+var ErrChallengeExpired = errors.New("sms challenge has expired")
+
+// Error codes observed in LKDR backend responses.
+// Other failures have no known code and are classified by HTTP status (see ErrorCategoryOf).
+const (
+	SmsVerificationNotExpired ErrorCode = "registration.sms.verification.not.expired"
+	BlockedCaptcha            ErrorCode = "blocked.captcha"
+	ReceiptFiscalDataNotFound ErrorCode = "receipt.fiscaldata.not.found.dr"
+)
+
+// ErrorCategory groups errors by how the caller is expected to react.
+type ErrorCategory int
+
+const (
+	UnknownError ErrorCategory = iota
+	ChallengeError
+	CaptchaError
+	AuthError
+	RateLimitError
+	TransientError
+	NotFoundError
+)
+
+var errorCategories = map[ErrorCode]ErrorCategory{
+	SmsVerificationNotExpired: ChallengeError,
+	BlockedCaptcha:            CaptchaError,
+	ReceiptFiscalDataNotFound: NotFoundError,
+}
+
+// Category returns the category of the error code, or UnknownError if the code is not in the catalogue.
+func (c ErrorCode) Category() ErrorCategory {
+	return errorCategories[c]
+}
+
+// Known reports whether the error code is in the catalogue.
+func (c ErrorCode) Known() bool {
+	_, ok := errorCategories[c]
+	return ok
+}
+
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e Error) Error() string {
+	var b strings.Builder
+	if e.Code != "" {
+		b.WriteString(string(e.Code))
+		if e.Message != "" {
+			b.WriteString(" (" + e.Message + ")")
+		}
+	} else if e.Message != "" {
+		b.WriteString(e.Message)
+	}
+
+	return b.String()
+}
+
+// ErrorCodeOf returns the code of Error in err chain, if any.
+func ErrorCodeOf(err error) (ErrorCode, bool) {
+	var e Error
+	if errors.As(err, &e) {
+		return e.Code, true
+	}
+
+	return "", false
+}
+
+// ErrorCategoryOf returns the category of Error code in err chain.
+// Errors without a known code are classified by HTTP status: 401 is AuthError, 429 is RateLimitError
+// and 5xx is TransientError. Other 4xx responses to SMS code verification are ChallengeError,
+// as well as ErrChallengeExpired. Otherwise UnknownError is returned.
+func ErrorCategoryOf(err error) ErrorCategory {
+	if errors.Is(err, ErrChallengeExpired) {
+		return ChallengeError
+	}
+
+	if code, _ := ErrorCodeOf(err); code.Known() {
+		return code.Category()
+	}
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return UnknownError
+	}
+
+	switch status := httpErr.StatusCode; {
+	case status == http.StatusUnauthorized:
+		return AuthError
+	case status == http.StatusTooManyRequests:
+		return RateLimitError
+	case status >= http.StatusInternalServerError:
+		return TransientError
+	case status >= http.StatusBadRequest && httpErr.Path == (verifyIn{}).path():
+		return ChallengeError
+	default:
+		return UnknownError
+	}
+}
+
+func IsDataNotFound(err error) bool {
+	var e Error
+	if errors.As(err, &e); e.Code == ReceiptFiscalDataNotFound {
+		return true
+	}
+
+	return false
+}
+
+// IsAuthError reports whether err means that the tokens were rejected and must be renewed.
+func IsAuthError(err error) bool {
+	return ErrorCategoryOf(err) == AuthError
+}
+
+// IsChallengeError reports whether err is caused by the state of SMS challenge, e.g. an active challenge
+// preventing a new one, an expired challenge (see ErrChallengeExpired) or a rejected SMS code.
+// Invalid code and too many attempts have no known error codes, so they can not be told apart.
+func IsChallengeError(err error) bool {
+	return ErrorCategoryOf(err) == ChallengeError
+}
+
+// IsCaptchaBlocked reports whether err is caused by captcha token rejected by the server.
+func IsCaptchaBlocked(err error) bool {
+	return ErrorCategoryOf(err) == CaptchaError
+}
+
+// IsRateLimited reports whether err is caused by exceeding server limits.
+func IsRateLimited(err error) bool {
+	return ErrorCategoryOf(err) == RateLimitError
+}
+
+// IsRetryable reports whether the request which failed with err may succeed if repeated later.
+// This includes network errors, throttling and server-side failures.
+func IsRetryable(err error) bool {
+	switch ErrorCategoryOf(err) {
+	case TransientError, RateLimitError:
+		return true
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
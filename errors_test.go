@@ -0,0 +1,45 @@
+package lkdr_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+func TestErrorCategoryOf(t *testing.T) {
+	httpErr := func(status int, path string, code lkdr.ErrorCode) error {
+		err := &lkdr.HTTPError{StatusCode: status, Path: path}
+		if code != "" {
+			err.Err = &lkdr.Error{Code: code}
+		}
+
+		return errors.Wrap(err, "request")
+	}
+
+	for _, tc := range []struct {
+		name     string
+		err      error
+		category lkdr.ErrorCategory
+	}{
+		{"active challenge", httpErr(http.StatusBadRequest, lkdrtest.StartPath, lkdr.SmsVerificationNotExpired), lkdr.ChallengeError},
+		{"blocked captcha", httpErr(http.StatusBadRequest, lkdrtest.StartPath, lkdr.BlockedCaptcha), lkdr.CaptchaError},
+		{"fiscal data not found", httpErr(http.StatusBadRequest, lkdrtest.FiscalDataPath, lkdr.ReceiptFiscalDataNotFound), lkdr.NotFoundError},
+		{"unauthorized", httpErr(http.StatusUnauthorized, lkdrtest.ReceiptPath, ""), lkdr.AuthError},
+		{"too many requests", httpErr(http.StatusTooManyRequests, lkdrtest.ReceiptPath, ""), lkdr.RateLimitError},
+		{"server error", httpErr(http.StatusBadGateway, lkdrtest.ReceiptPath, ""), lkdr.TransientError},
+		{"rejected code", httpErr(http.StatusBadRequest, lkdrtest.VerifyPath, ""), lkdr.ChallengeError},
+		{"rejected code with unknown error code", httpErr(http.StatusBadRequest, lkdrtest.VerifyPath, "unknown"), lkdr.ChallengeError},
+		{"unknown bad request", httpErr(http.StatusBadRequest, lkdrtest.ReceiptPath, "unknown"), lkdr.UnknownError},
+		{"expired challenge", errors.Wrap(lkdr.ErrChallengeExpired, "verify"), lkdr.ChallengeError},
+		{"other error", errors.New("decode json"), lkdr.UnknownError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.category, lkdr.ErrorCategoryOf(tc.err))
+		})
+	}
+}
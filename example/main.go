@@ -3,10 +3,8 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/caarlos0/env"
@@ -15,106 +13,11 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/captcha"
+	"github.com/jfk9w-go/lkdr-api/filestorage"
 )
 
-type jsonTokenStorage struct {
-	path string
-}
-
-func (s jsonTokenStorage) LoadTokens(ctx context.Context, phone string) (*lkdr.Tokens, error) {
-	file, err := s.open(os.O_RDONLY)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
-		}
-
-		return nil, err
-	}
-
-	defer file.Close()
-	contents := make(map[string]lkdr.Tokens)
-	if err := json.NewDecoder(file).Decode(&contents); err != nil {
-		return nil, errors.Wrap(err, "decode json")
-	}
-
-	if tokens, ok := contents[phone]; ok {
-		return &tokens, nil
-	}
-
-	return nil, nil
-}
-
-func (s jsonTokenStorage) UpdateTokens(ctx context.Context, phone string, tokens *lkdr.Tokens) error {
-	file, err := s.open(os.O_RDWR | os.O_CREATE)
-	if err != nil {
-		return err
-	}
-
-	stat, err := file.Stat()
-	if err != nil {
-		return errors.Wrap(err, "stat")
-	}
-
-	contents := make(map[string]lkdr.Tokens)
-	if stat.Size() > 0 {
-		if err := json.NewDecoder(file).Decode(&contents); err != nil {
-			return errors.Wrap(err, "decode json")
-		}
-	}
-
-	if tokens != nil {
-		contents[phone] = *tokens
-	} else {
-		delete(contents, phone)
-	}
-
-	if err := file.Truncate(0); err != nil {
-		return errors.Wrap(err, "truncate file")
-	}
-
-	if _, err := file.Seek(0, 0); err != nil {
-		return errors.Wrap(err, "seek to the start of file")
-	}
-
-	if err := json.NewEncoder(file).Encode(&contents); err != nil {
-		return errors.Wrap(err, "encode json")
-	}
-
-	return nil
-}
-
-func (s jsonTokenStorage) open(flag int) (*os.File, error) {
-	if err := os.MkdirAll(filepath.Dir(s.path), os.ModeDir); err != nil {
-		return nil, errors.Wrap(err, "create parent directory")
-	}
-
-	file, err := os.OpenFile(s.path, flag, 0644)
-	if err != nil {
-		return nil, errors.Wrap(err, "open file")
-	}
-
-	return file, nil
-}
-
-type authorizer struct {
-	rucaptchaClient *rucaptcha.Client
-}
-
-func (a *authorizer) GetCaptchaToken(ctx context.Context, userAgent, siteKey, pageURL string) (string, error) {
-	solved, err := a.rucaptchaClient.Solve(ctx, &rucaptcha.YandexSmartCaptchaIn{
-		UserAgent: userAgent,
-		SiteKey:   siteKey,
-		PageURL:   pageURL,
-	})
-
-	if err != nil {
-		return "", err
-	}
-
-	return solved.Answer, nil
-}
-
-func (a *authorizer) GetConfirmationCode(ctx context.Context, phone string) (string, error) {
+func readConfirmationCode(ctx context.Context, phone string) (string, error) {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Printf("Enter confirmation code for %s: ", phone)
 	text, err := reader.ReadString('\n')
@@ -141,13 +44,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	client, err := lkdr.NewClient(lkdr.ClientParams{
-		Phone:     config.Phone,
-		Clock:     based.StandardClock,
-		DeviceID:  config.DeviceID,
-		UserAgent: config.UserAgent,
-		TokenStorage: jsonTokenStorage{
-			path: config.TokensFile,
+	rucaptchaClient, err := rucaptcha.NewClient(rucaptcha.ClientParams{
+		Config: rucaptcha.Config{
+			Key: config.RucaptchaKey,
 		},
 	})
 
@@ -155,9 +54,15 @@ func main() {
 		panic(err)
 	}
 
-	rucaptchaClient, err := rucaptcha.NewClient(rucaptcha.ClientParams{
-		Config: rucaptcha.Config{
-			Key: config.RucaptchaKey,
+	client, err := lkdr.NewClient(lkdr.ClientParams{
+		Phone:        config.Phone,
+		Clock:        based.StandardClock,
+		DeviceID:     config.DeviceID,
+		UserAgent:    config.UserAgent,
+		TokenStorage: filestorage.New(config.TokensFile),
+		Authorizer: lkdr.CompositeAuthorizer{
+			CaptchaSolver:            captcha.NewRucaptcha(rucaptchaClient),
+			ConfirmationCodeProvider: lkdr.ConfirmationCodeFunc(readConfirmationCode),
 		},
 	})
 
@@ -165,8 +70,6 @@ func main() {
 		panic(err)
 	}
 
-	ctx = lkdr.WithAuthorizer(ctx, &authorizer{rucaptchaClient: rucaptchaClient})
-
 	receipts, err := client.Receipt(ctx, &lkdr.ReceiptIn{
 		Limit:   1,
 		Offset:  0,
@@ -0,0 +1,16 @@
+package lkdr
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jfk9w-go/based"
+)
+
+func (p *RetryPolicy) Delay(clock based.Clock, attempt int, err error) (time.Duration, bool) {
+	return p.delay(clock, attempt, err)
+}
+
+func RetryAfter(clock based.Clock, header http.Header) (time.Duration, bool) {
+	return retryAfter(clock, header)
+}
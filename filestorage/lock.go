@@ -0,0 +1,48 @@
+package filestorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const lockPollInterval = 50 * time.Millisecond
+
+// Lock acquires an advisory lock on the file at path, creating it if necessary.
+// Shared locks may be held by multiple holders at once, exclusive lock excludes any other holder.
+// The returned function releases the lock.
+func Lock(ctx context.Context, path string, exclusive bool) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return nil, errors.Wrap(err, "create lock directory")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, filePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, "open lock file")
+	}
+
+	for {
+		ok, err := tryLock(file, exclusive)
+		if err != nil {
+			_ = file.Close()
+			return nil, errors.Wrap(err, "lock file")
+		}
+
+		if ok {
+			return func() {
+				_ = unlock(file)
+				_ = file.Close()
+			}, nil
+		}
+
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
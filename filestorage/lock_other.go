@@ -0,0 +1,17 @@
+//go:build !unix && !windows
+
+package filestorage
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func tryLock(file *os.File, exclusive bool) (bool, error) {
+	return false, errors.New("file locking is not supported on this platform")
+}
+
+func unlock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,235 @@
+// Package filestorage provides lkdr.TokenStorage, lkdr.ChallengeStorage and lkdr.TokenLocker backed by a JSON file.
+//
+// The file is shared by any number of phones and processes: reads and writes are serialized
+// with an advisory lock on a sibling ".lock" file, and updates are written to a temporary file
+// which is then atomically renamed over the original one.
+package filestorage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+const (
+	dirPerm  = 0o700
+	filePerm = 0o600
+)
+
+type contents struct {
+	Tokens     map[string]*lkdr.Tokens    `json:"tokens"`
+	Challenges map[string]*lkdr.Challenge `json:"challenges,omitempty"`
+}
+
+// Storage is a file-based storage. It is safe for concurrent use by multiple goroutines and processes.
+type Storage struct {
+	path string
+}
+
+// New creates a Storage for the file at path. The file and its parent directories are created on first update.
+func New(path string) *Storage {
+	return &Storage{path: path}
+}
+
+func (s *Storage) LoadTokens(ctx context.Context, phone string) (*lkdr.Tokens, error) {
+	var tokens *lkdr.Tokens
+	err := s.read(ctx, func(c *contents) {
+		tokens = c.Tokens[phone]
+	})
+
+	return tokens, err
+}
+
+func (s *Storage) UpdateTokens(ctx context.Context, phone string, tokens *lkdr.Tokens) error {
+	return s.update(ctx, func(c *contents) {
+		if tokens != nil {
+			c.Tokens[phone] = tokens
+		} else {
+			delete(c.Tokens, phone)
+		}
+	})
+}
+
+func (s *Storage) LoadChallenge(ctx context.Context, phone string) (*lkdr.Challenge, error) {
+	var challenge *lkdr.Challenge
+	err := s.read(ctx, func(c *contents) {
+		challenge = c.Challenges[phone]
+	})
+
+	return challenge, err
+}
+
+func (s *Storage) UpdateChallenge(ctx context.Context, phone string, challenge *lkdr.Challenge) error {
+	return s.update(ctx, func(c *contents) {
+		if challenge != nil {
+			c.Challenges[phone] = challenge
+		} else {
+			delete(c.Challenges, phone)
+		}
+	})
+}
+
+// LockTokens implements lkdr.TokenLocker with an exclusive lock on a per-phone sibling file.
+func (s *Storage) LockTokens(ctx context.Context, phone string) (func(), error) {
+	return Lock(ctx, s.path+"."+sanitize(phone)+".lock", true)
+}
+
+func (s *Storage) read(ctx context.Context, fn func(c *contents)) error {
+	unlock, err := s.lock(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	c, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	fn(c)
+	return nil
+}
+
+func (s *Storage) update(ctx context.Context, fn func(c *contents)) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), dirPerm); err != nil {
+		return errors.Wrap(err, "create parent directory")
+	}
+
+	unlock, err := s.lock(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	c, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	fn(c)
+	return s.store(c)
+}
+
+func (s *Storage) lock(ctx context.Context, exclusive bool) (func(), error) {
+	return Lock(ctx, s.path+".lock", exclusive)
+}
+
+func (s *Storage) load() (*contents, error) {
+	c := &contents{
+		Tokens:     make(map[string]*lkdr.Tokens),
+		Challenges: make(map[string]*lkdr.Challenge),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	if len(data) == 0 {
+		return c, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "decode json")
+	}
+
+	for key := range raw {
+		if key != "tokens" && key != "challenges" {
+			// Legacy format: a plain map of phones to tokens.
+			if err := json.Unmarshal(data, &c.Tokens); err != nil {
+				return nil, errors.Wrap(err, "decode legacy json")
+			}
+
+			return c, nil
+		}
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, errors.Wrap(err, "decode json")
+	}
+
+	if c.Tokens == nil {
+		c.Tokens = make(map[string]*lkdr.Tokens)
+	}
+
+	if c.Challenges == nil {
+		c.Challenges = make(map[string]*lkdr.Challenge)
+	}
+
+	return c, nil
+}
+
+func (s *Storage) store(c *contents) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "encode json")
+	}
+
+	return WriteFileAtomic(s.path, data, filePerm)
+}
+
+func sanitize(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '+' || r == '-' {
+			return r
+		}
+
+		return '_'
+	}, value)
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory and renames it to path,
+// so that readers observe either old or new contents, but never a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return errors.Wrap(err, "write temporary file")
+	}
+
+	if err := file.Chmod(perm); err != nil {
+		_ = file.Close()
+		return errors.Wrap(err, "chmod temporary file")
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return errors.Wrap(err, "sync temporary file")
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "close temporary file")
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Wrap(err, "rename temporary file")
+	}
+
+	if dir, err := os.Open(dir); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package filestorage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/filestorage"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.json")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o644))
+	require.NoError(t, filestorage.WriteFileAtomic(path, []byte("new"), 0o600))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary file is removed")
+	assert.Equal(t, "file.json", entries[0].Name())
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("missing file", func(t *testing.T) {
+		storage := filestorage.New(filepath.Join(t.TempDir(), "missing", "tokens.json"))
+		tokens, err := storage.LoadTokens(ctx, "79999999999")
+		require.NoError(t, err)
+		assert.Nil(t, tokens)
+	})
+
+	t.Run("several phones", func(t *testing.T) {
+		storage := filestorage.New(filepath.Join(t.TempDir(), "nested", "tokens.json"))
+		phones := []string{"79999999999", "78888888888"}
+		for _, phone := range phones {
+			require.NoError(t, storage.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: phone}))
+			require.NoError(t, storage.UpdateChallenge(ctx, phone, &lkdr.Challenge{Token: phone}))
+		}
+
+		for _, phone := range phones {
+			tokens, err := storage.LoadTokens(ctx, phone)
+			require.NoError(t, err)
+			require.NotNil(t, tokens)
+			assert.Equal(t, phone, tokens.Token)
+
+			challenge, err := storage.LoadChallenge(ctx, phone)
+			require.NoError(t, err)
+			require.NotNil(t, challenge)
+			assert.Equal(t, phone, challenge.Token)
+		}
+
+		require.NoError(t, storage.UpdateTokens(ctx, phones[0], nil))
+		require.NoError(t, storage.UpdateChallenge(ctx, phones[1], nil))
+
+		tokens, err := storage.LoadTokens(ctx, phones[0])
+		require.NoError(t, err)
+		assert.Nil(t, tokens)
+		tokens, err = storage.LoadTokens(ctx, phones[1])
+		require.NoError(t, err)
+		assert.NotNil(t, tokens)
+
+		challenge, err := storage.LoadChallenge(ctx, phones[0])
+		require.NoError(t, err)
+		assert.NotNil(t, challenge)
+		challenge, err = storage.LoadChallenge(ctx, phones[1])
+		require.NoError(t, err)
+		assert.Nil(t, challenge)
+	})
+
+	t.Run("legacy format", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"79999999999":{"token":"token","refreshToken":"refresh"}}`), 0o600))
+		storage := filestorage.New(path)
+
+		tokens, err := storage.LoadTokens(ctx, "79999999999")
+		require.NoError(t, err)
+		require.NotNil(t, tokens)
+		assert.Equal(t, "token", tokens.Token)
+		assert.Equal(t, "refresh", tokens.RefreshToken)
+
+		require.NoError(t, storage.UpdateChallenge(ctx, "79999999999", &lkdr.Challenge{Token: "challenge"}))
+		tokens, err = storage.LoadTokens(ctx, "79999999999")
+		require.NoError(t, err)
+		require.NotNil(t, tokens, "legacy tokens are kept on update")
+		assert.Equal(t, "token", tokens.Token)
+	})
+}
+
+func TestStorage_LockTokens(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	first, second := filestorage.New(path), filestorage.New(path)
+
+	unlock, err := first.LockTokens(ctx, "79999999999")
+	require.NoError(t, err)
+
+	t.Run("other phones are not locked", func(t *testing.T) {
+		unlock, err := second.LockTokens(ctx, "78888888888")
+		require.NoError(t, err)
+		unlock()
+	})
+
+	t.Run("context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		_, err := second.LockTokens(ctx, "79999999999")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("lock is acquired after release", func(t *testing.T) {
+		acquired := make(chan func())
+		go func() {
+			unlock, err := second.LockTokens(ctx, "79999999999")
+			assert.NoError(t, err)
+			acquired <- unlock
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("lock is acquired by two holders")
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		unlock()
+		select {
+		case unlock := <-acquired:
+			unlock()
+		case <-time.After(5 * time.Second):
+			t.Fatal("lock is not acquired after release")
+		}
+	})
+}
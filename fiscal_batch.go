@@ -0,0 +1,103 @@
+package lkdr
+
+import (
+	"context"
+	"iter"
+	"sync"
+)
+
+const defaultFiscalDataWorkers = 4
+
+type FiscalDataBatchOptions struct {
+	// Workers limits the number of concurrent requests. Requests are also subject to RateLimiter.
+	Workers int
+}
+
+// FiscalDataResult is the outcome of fetching fiscal data for a receipt key.
+// Exactly one of Data, NotFound and Err is set.
+type FiscalDataResult struct {
+	Key  string
+	Data *FiscalDataOut
+
+	// NotFound means that fiscal data is not yet available (see IsDataNotFound).
+	NotFound bool
+
+	Err error
+}
+
+// FiscalDataBatch fetches fiscal data for the keys concurrently and yields results as they arrive, in no particular order.
+// Failures are reported per key and do not stop the batch.
+// Stopping the iteration or cancelling the context cancels pending requests, and keys which were not requested yet are skipped.
+func (c *Client) FiscalDataBatch(ctx context.Context, keys []string, opts *FiscalDataBatchOptions) iter.Seq[FiscalDataResult] {
+	return func(yield func(FiscalDataResult) bool) {
+		if len(keys) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		workers := defaultFiscalDataWorkers
+		if opts != nil && opts.Workers > 0 {
+			workers = opts.Workers
+		}
+
+		var (
+			jobs    = make(chan string)
+			results = make(chan FiscalDataResult)
+			wg      sync.WaitGroup
+		)
+
+		go func() {
+			defer close(jobs)
+			for _, key := range keys {
+				select {
+				case jobs <- key:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		for range min(workers, len(keys)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for key := range jobs {
+					select {
+					case results <- c.fiscalDataResult(ctx, key):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		for result := range results {
+			if !yield(result) {
+				cancel()
+				for range results {
+				}
+
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) fiscalDataResult(ctx context.Context, key string) FiscalDataResult {
+	data, err := c.FiscalData(ctx, &FiscalDataIn{Key: key})
+	switch {
+	case err == nil:
+		return FiscalDataResult{Key: key, Data: data}
+	case IsDataNotFound(err):
+		return FiscalDataResult{Key: key, NotFound: true}
+	default:
+		return FiscalDataResult{Key: key, Err: err}
+	}
+}
@@ -0,0 +1,78 @@
+package lkdr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MaxHTTPErrorBodySize limits the number of response body bytes kept in HTTPError.
+const MaxHTTPErrorBodySize = 4 << 10
+
+// HTTPError is returned when the server responds with non-200 status.
+type HTTPError struct {
+	StatusCode int
+	Path       string
+	Header     http.Header
+
+	// Body is the raw response body truncated to MaxHTTPErrorBodySize bytes.
+	Body      []byte
+	Truncated bool
+
+	// Err is the decoded response body, if it is a valid Error.
+	Err *Error
+}
+
+func newHTTPError(path string, httpResp *http.Response) *HTTPError {
+	httpErr := &HTTPError{
+		StatusCode: httpResp.StatusCode,
+		Path:       path,
+		Header:     httpResp.Header,
+	}
+
+	if httpResp.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(httpResp.Body, MaxHTTPErrorBodySize+1))
+		if len(body) > MaxHTTPErrorBodySize {
+			body, httpErr.Truncated = body[:MaxHTTPErrorBodySize], true
+		}
+
+		httpErr.Body = body
+	}
+
+	var clientErr Error
+	if err := json.Unmarshal(httpErr.Body, &clientErr); err == nil && (clientErr.Code != "" || clientErr.Message != "") {
+		httpErr.Err = &clientErr
+	}
+
+	return httpErr
+}
+
+func (e *HTTPError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Path + ": " + fmt.Sprint(e.StatusCode))
+	if text := http.StatusText(e.StatusCode); text != "" {
+		b.WriteString(" " + text)
+	}
+
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	} else if body := strings.TrimSpace(string(e.Body)); body != "" {
+		b.WriteString(": " + body)
+		if e.Truncated {
+			b.WriteString("...")
+		}
+	}
+
+	return b.String()
+}
+
+// Unwrap returns the decoded Error, so that errors.As and IsDataNotFound work on HTTPError.
+func (e *HTTPError) Unwrap() error {
+	if e.Err != nil {
+		return *e.Err
+	}
+
+	return nil
+}
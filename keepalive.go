@@ -0,0 +1,108 @@
+package lkdr
+
+import (
+	"context"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultKeepAliveInterval     = time.Minute
+	defaultKeepAliveRefreshAhead = 15 * time.Minute
+	defaultKeepAliveWarnAhead    = 7 * 24 * time.Hour
+)
+
+type KeepAliveParams struct {
+	// Interval between token checks.
+	Interval time.Duration
+
+	// RefreshAhead is the time before access token expiry when it is refreshed.
+	RefreshAhead time.Duration
+
+	// WarnAhead is the time before refresh token expiry when OnRefreshTokenExpiring is called.
+	WarnAhead time.Duration
+
+	// OnRefreshTokenExpiring is called once per refresh token when it is about to expire,
+	// so that a human can log in again before it happens.
+	OnRefreshTokenExpiring func(ctx context.Context, phone string, expiresAt time.Time)
+
+	// OnError is called when a check fails. Checks are retried on next tick.
+	OnError func(ctx context.Context, phone string, err error)
+}
+
+// StartKeepAlive starts a background loop which refreshes access token ahead of expiry.
+// It never performs interactive authorization: if the refresh token is expired or rejected,
+// OnError is called with ErrReauthRequired instead.
+func (c *Client) StartKeepAlive(ctx context.Context, params KeepAliveParams) based.Goroutine {
+	if params.Interval <= 0 {
+		params.Interval = defaultKeepAliveInterval
+	}
+
+	if params.RefreshAhead <= 0 {
+		params.RefreshAhead = defaultKeepAliveRefreshAhead
+	}
+
+	if params.WarnAhead <= 0 {
+		params.WarnAhead = defaultKeepAliveWarnAhead
+	}
+
+	return based.Go(WithNonInteractive(ctx), func(ctx context.Context) {
+		ticker := time.NewTicker(params.Interval)
+		defer ticker.Stop()
+
+		var warned time.Time
+		for {
+			expiresAt, err := c.keepAlive(ctx, params.RefreshAhead)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if params.OnError != nil {
+					params.OnError(ctx, c.phone, err)
+				}
+			} else if !expiresAt.IsZero() && !expiresAt.Equal(warned) && expiresAt.Before(c.clock.Now().Add(params.WarnAhead)) {
+				warned = expiresAt
+				if params.OnRefreshTokenExpiring != nil {
+					params.OnRefreshTokenExpiring(ctx, c.phone, expiresAt)
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// keepAlive refreshes access token if it expires within ahead and returns refresh token expiry time, if known.
+func (c *Client) keepAlive(ctx context.Context, ahead time.Duration) (time.Time, error) {
+	tokens, err := c.tokens.get(ctx)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "load token")
+	}
+
+	if tokens == nil {
+		return time.Time{}, errors.New("not logged in")
+	}
+
+	if tokens.TokenExpireIn.Time().Before(c.clock.Now().Add(ahead)) {
+		if _, err := c.ensureToken(ctx, tokens.Token); err != nil {
+			return time.Time{}, errors.Wrap(err, "refresh token")
+		}
+
+		if tokens, err = c.tokens.get(ctx); err != nil {
+			return time.Time{}, errors.Wrap(err, "load token")
+		}
+	}
+
+	if tokens == nil || tokens.RefreshTokenExpiresIn == nil {
+		return time.Time{}, nil
+	}
+
+	return tokens.RefreshTokenExpiresIn.Time(), nil
+}
@@ -0,0 +1,43 @@
+package lkdrtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Authorizer is an lkdr.Authorizer which reads confirmation codes from the Server.
+// Captcha tokens are accepted by the fake server as is.
+type Authorizer struct {
+	Server *Server
+
+	// Fails is the number of first GetConfirmationCode calls which return an error,
+	// as if the SMS was not delivered.
+	Fails int
+
+	calls int
+	mu    sync.Mutex
+}
+
+func (a *Authorizer) GetCaptchaToken(ctx context.Context, userAgent, siteKey, pageURL string) (string, error) {
+	return "captcha", nil
+}
+
+func (a *Authorizer) GetConfirmationCode(ctx context.Context, phone string) (string, error) {
+	a.mu.Lock()
+	a.calls++
+	fail := a.calls <= a.Fails
+	a.mu.Unlock()
+
+	if fail {
+		return "", errors.New("sms is not delivered")
+	}
+
+	code, ok := a.Server.Code(phone)
+	if !ok {
+		return "", errors.New("no active challenge")
+	}
+
+	return code, nil
+}
@@ -0,0 +1,30 @@
+package lkdrtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a based.Clock which only moves when advanced explicitly.
+type Clock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+// NewClock creates a Clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
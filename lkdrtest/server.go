@@ -0,0 +1,627 @@
+// Package lkdrtest provides an in-process fake of the LKDR backend for offline tests.
+package lkdrtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+const (
+	StartPath      = "/v2/auth/challenge/sms/start"
+	VerifyPath     = "/v1/auth/challenge/sms/verify"
+	TokenPath      = "/v1/auth/token"
+	ReceiptPath    = "/v1/receipt"
+	FiscalDataPath = "/v1/receipt/fiscal_data"
+)
+
+const (
+	defaultTokenTTL        = time.Hour
+	defaultRefreshTokenTTL = 90 * 24 * time.Hour
+	defaultChallengeTTL    = 2 * time.Minute
+	defaultReceiptLimit    = 10
+	maxVerifyAttempts      = 3
+)
+
+// ServerParams configures the fake server. Zero values are replaced with sensible defaults.
+type ServerParams struct {
+	// Clock is used for all expiry checks. Defaults to based.StandardClock.
+	Clock based.Clock
+
+	// Code is the SMS code accepted for every challenge. A random code is generated per challenge if empty.
+	Code string
+
+	TokenTTL        time.Duration
+	RefreshTokenTTL time.Duration
+	ChallengeTTL    time.Duration
+}
+
+// Failure describes a programmed error response.
+type Failure struct {
+	Status int
+	Header http.Header
+
+	// Error is encoded as JSON response body if set, otherwise Body is written as is.
+	Error *lkdr.Error
+	Body  []byte
+}
+
+type challenge struct {
+	phone     string
+	token     string
+	code      string
+	expiresAt time.Time
+	attempts  int
+}
+
+type session struct {
+	phone     string
+	expiresAt time.Time
+}
+
+// Server emulates LKDR authorization and receipt endpoints on top of httptest.Server.
+type Server struct {
+	server *httptest.Server
+	clock  based.Clock
+	params ServerParams
+
+	mu            sync.Mutex
+	challenges    map[string]*challenge
+	tokens        map[string]session
+	refreshTokens map[string]session
+	receipts      map[string][]lkdr.Receipt
+	brands        map[int64]lkdr.Brand
+	fiscalData    map[string]*lkdr.FiscalDataOut
+	failures      map[string][]Failure
+	requests      map[string]int
+}
+
+// NewServer starts a new fake server. It must be closed after use.
+func NewServer(params ServerParams) *Server {
+	if params.Clock == nil {
+		params.Clock = based.StandardClock
+	}
+
+	if params.TokenTTL <= 0 {
+		params.TokenTTL = defaultTokenTTL
+	}
+
+	if params.RefreshTokenTTL <= 0 {
+		params.RefreshTokenTTL = defaultRefreshTokenTTL
+	}
+
+	if params.ChallengeTTL <= 0 {
+		params.ChallengeTTL = defaultChallengeTTL
+	}
+
+	s := &Server{
+		clock:         params.Clock,
+		params:        params,
+		challenges:    make(map[string]*challenge),
+		tokens:        make(map[string]session),
+		refreshTokens: make(map[string]session),
+		receipts:      make(map[string][]lkdr.Receipt),
+		brands:        make(map[int64]lkdr.Brand),
+		fiscalData:    make(map[string]*lkdr.FiscalDataOut),
+		failures:      make(map[string][]Failure),
+		requests:      make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+StartPath, s.handleStart)
+	mux.HandleFunc("POST "+VerifyPath, s.handleVerify)
+	mux.HandleFunc("POST "+TokenPath, s.handleToken)
+	mux.HandleFunc("POST "+ReceiptPath, s.handleReceipt)
+	mux.HandleFunc("POST "+FiscalDataPath, s.handleFiscalData)
+	s.server = httptest.NewServer(s.intercept(mux))
+
+	return s
+}
+
+// URL returns the base URL to be used as lkdr.ClientParams.BaseURL.
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Client returns an HTTP client configured to talk to this server.
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// AddReceipts seeds receipts visible to the phone.
+func (s *Server) AddReceipts(phone string, receipts ...lkdr.Receipt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receipts[phone] = append(s.receipts[phone], receipts...)
+}
+
+// AddBrands seeds brands which are returned along with receipts referencing them.
+func (s *Server) AddBrands(brands ...lkdr.Brand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, brand := range brands {
+		s.brands[brand.Id] = brand
+	}
+}
+
+// SetFiscalData seeds fiscal data for the receipt key. Passing nil removes it,
+// so that the server responds with lkdr.ReceiptFiscalDataNotFound.
+func (s *Server) SetFiscalData(key string, fiscalData *lkdr.FiscalDataOut) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fiscalData == nil {
+		delete(s.fiscalData, key)
+	} else {
+		s.fiscalData[key] = fiscalData
+	}
+}
+
+// Fail queues failures to be returned by subsequent requests to the path, one per request.
+func (s *Server) Fail(path string, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = append(s.failures[path], failures...)
+}
+
+// Requests returns the number of requests received for the path, including failed ones.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// Code returns the SMS code of the active challenge for the phone, if any.
+func (s *Server) Code(phone string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for _, c := range s.challenges {
+		if c.phone == phone && now.Before(c.expiresAt) {
+			return c.code, true
+		}
+	}
+
+	return "", false
+}
+
+// IssueTokens creates a valid token pair for the phone, bypassing SMS challenge.
+func (s *Server) IssueTokens(phone string) *lkdr.Tokens {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueTokens(phone)
+}
+
+// ExpireTokens invalidates all access tokens issued for the phone, keeping refresh tokens intact.
+func (s *Server) ExpireTokens(phone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, session := range s.tokens {
+		if session.phone == phone {
+			delete(s.tokens, token)
+		}
+	}
+}
+
+// RevokeTokens invalidates all access and refresh tokens issued for the phone.
+func (s *Server) RevokeTokens(phone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, session := range s.tokens {
+		if session.phone == phone {
+			delete(s.tokens, token)
+		}
+	}
+
+	for token, session := range s.refreshTokens {
+		if session.phone == phone {
+			delete(s.refreshTokens, token)
+		}
+	}
+}
+
+func (s *Server) intercept(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		var (
+			failure Failure
+			fail    bool
+		)
+
+		if failures := s.failures[r.URL.Path]; len(failures) > 0 {
+			failure, fail = failures[0], true
+			s.failures[r.URL.Path] = failures[1:]
+		}
+
+		s.mu.Unlock()
+
+		if fail {
+			writeFailure(w, failure)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type deviceInfo struct {
+	SourceDeviceId string `json:"sourceDeviceId"`
+}
+
+type startIn struct {
+	DeviceInfo   deviceInfo `json:"deviceInfo"`
+	Phone        string     `json:"phone"`
+	CaptchaToken string     `json:"captchaToken"`
+}
+
+type startOut struct {
+	ChallengeToken             string                   `json:"challengeToken"`
+	ChallengeTokenExpiresIn    lkdr.DateTimeMilliOffset `json:"challengeTokenExpiresIn"`
+	ChallengeTokenExpiresInSec int                      `json:"challengeTokenExpiresInSec"`
+}
+
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	var in startIn
+	if !decode(w, r, &in) {
+		return
+	}
+
+	if in.Phone == "" || in.CaptchaToken == "" {
+		writeError(w, http.StatusBadRequest, lkdr.Error{Message: "phone and captchaToken are required"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for token, c := range s.challenges {
+		if c.phone != in.Phone {
+			continue
+		}
+
+		if now.Before(c.expiresAt) {
+			writeError(w, http.StatusBadRequest, lkdr.Error{
+				Code:    lkdr.SmsVerificationNotExpired,
+				Message: "sms verification has not expired yet",
+			})
+
+			return
+		}
+
+		delete(s.challenges, token)
+	}
+
+	code := s.params.Code
+	if code == "" {
+		code = randomCode()
+	}
+
+	c := &challenge{
+		phone:     in.Phone,
+		token:     randomToken(),
+		code:      code,
+		expiresAt: now.Add(s.params.ChallengeTTL),
+	}
+
+	s.challenges[c.token] = c
+	writeJSON(w, startOut{
+		ChallengeToken:             c.token,
+		ChallengeTokenExpiresIn:    lkdr.DateTimeMilliOffset(c.expiresAt),
+		ChallengeTokenExpiresInSec: int(s.params.ChallengeTTL.Seconds()),
+	})
+}
+
+type verifyIn struct {
+	DeviceInfo     deviceInfo `json:"deviceInfo"`
+	Phone          string     `json:"phone"`
+	ChallengeToken string     `json:"challengeToken"`
+	Code           string     `json:"code"`
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var in verifyIn
+	if !decode(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[in.ChallengeToken]
+	if !ok || c.phone != in.Phone {
+		writeError(w, http.StatusBadRequest, lkdr.Error{Message: "challenge token is invalid"})
+
+		return
+	}
+
+	if !s.clock.Now().Before(c.expiresAt) {
+		delete(s.challenges, in.ChallengeToken)
+		writeError(w, http.StatusBadRequest, lkdr.Error{Message: "sms verification has expired"})
+
+		return
+	}
+
+	if c.code != in.Code {
+		c.attempts++
+		if c.attempts >= maxVerifyAttempts {
+			delete(s.challenges, in.ChallengeToken)
+			writeError(w, http.StatusBadRequest, lkdr.Error{Message: "too many invalid sms codes"})
+
+			return
+		}
+
+		writeError(w, http.StatusBadRequest, lkdr.Error{Message: "invalid sms code"})
+
+		return
+	}
+
+	delete(s.challenges, in.ChallengeToken)
+	writeJSON(w, s.issueTokens(in.Phone))
+}
+
+type tokenIn struct {
+	DeviceInfo   deviceInfo `json:"deviceInfo"`
+	RefreshToken string     `json:"refreshToken"`
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	var in tokenIn
+	if !decode(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.refreshTokens[in.RefreshToken]
+	if !ok || !s.clock.Now().Before(session.expiresAt) {
+		writeError(w, http.StatusUnauthorized, lkdr.Error{Message: "refresh token is invalid or expired"})
+
+		return
+	}
+
+	delete(s.refreshTokens, in.RefreshToken)
+	writeJSON(w, s.issueTokens(session.phone))
+}
+
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	phone, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var in lkdr.ReceiptIn
+	if !decode(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	receipts := make([]lkdr.Receipt, 0, len(s.receipts[phone]))
+	for _, receipt := range s.receipts[phone] {
+		if matchReceipt(&in, &receipt) {
+			receipts = append(receipts, receipt)
+		}
+	}
+
+	if err := sortReceipts(receipts, in.OrderBy); err != nil {
+		writeError(w, http.StatusBadRequest, lkdr.Error{Message: err.Error()})
+		return
+	}
+
+	limit := in.Limit
+	if limit <= 0 {
+		limit = defaultReceiptLimit
+	}
+
+	offset := min(max(in.Offset, 0), len(receipts))
+	end := min(offset+limit, len(receipts))
+
+	out := lkdr.ReceiptOut{
+		Brands:   []lkdr.Brand{},
+		Receipts: receipts[offset:end],
+		HasMore:  end < len(receipts),
+	}
+
+	seen := make(map[int64]bool)
+	for _, receipt := range out.Receipts {
+		if receipt.BrandId == nil || seen[*receipt.BrandId] {
+			continue
+		}
+
+		seen[*receipt.BrandId] = true
+		if brand, ok := s.brands[*receipt.BrandId]; ok {
+			out.Brands = append(out.Brands, brand)
+		}
+	}
+
+	writeJSON(w, out)
+}
+
+func (s *Server) handleFiscalData(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	var in lkdr.FiscalDataIn
+	if !decode(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fiscalData, ok := s.fiscalData[in.Key]
+	if !ok {
+		writeError(w, http.StatusBadRequest, lkdr.Error{
+			Code:    lkdr.ReceiptFiscalDataNotFound,
+			Message: "fiscal data not found",
+		})
+
+		return
+	}
+
+	writeJSON(w, fiscalData)
+}
+
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		s.mu.Lock()
+		session, found := s.tokens[token]
+		ok = found && s.clock.Now().Before(session.expiresAt)
+		s.mu.Unlock()
+		if ok {
+			return session.phone, true
+		}
+	}
+
+	writeError(w, http.StatusUnauthorized, lkdr.Error{Message: "access token is invalid or expired"})
+
+	return "", false
+}
+
+func (s *Server) issueTokens(phone string) *lkdr.Tokens {
+	now := s.clock.Now().UTC()
+	tokens := &lkdr.Tokens{
+		RefreshToken: randomToken(),
+		Token:        randomToken(),
+	}
+
+	refreshTokenExpiresIn := lkdr.DateTimeTZ(now.Add(s.params.RefreshTokenTTL))
+	tokens.RefreshTokenExpiresIn = &refreshTokenExpiresIn
+	tokens.TokenExpireIn = lkdr.DateTimeTZ(now.Add(s.params.TokenTTL))
+
+	s.tokens[tokens.Token] = session{phone: phone, expiresAt: tokens.TokenExpireIn.Time()}
+	s.refreshTokens[tokens.RefreshToken] = session{phone: phone, expiresAt: refreshTokenExpiresIn.Time()}
+	return tokens
+}
+
+func matchReceipt(in *lkdr.ReceiptIn, receipt *lkdr.Receipt) bool {
+	createdDate := receipt.CreatedDate.Time()
+	if in.DateFrom != nil && createdDate.Before(in.DateFrom.Time()) {
+		return false
+	}
+
+	if in.DateTo != nil && !createdDate.Before(in.DateTo.Time().AddDate(0, 0, 1)) {
+		return false
+	}
+
+	if in.Inn != nil && *in.Inn != receipt.KktOwnerInn {
+		return false
+	}
+
+	if in.KktOwner != "" && !strings.Contains(strings.ToLower(receipt.KktOwner), strings.ToLower(in.KktOwner)) {
+		return false
+	}
+
+	return true
+}
+
+func sortReceipts(receipts []lkdr.Receipt, orderBy string) error {
+	if orderBy == "" {
+		orderBy = "RECEIVE_DATE:DESC"
+	}
+
+	field, direction, _ := strings.Cut(orderBy, ":")
+	var key func(receipt *lkdr.Receipt) time.Time
+	switch field {
+	case "RECEIVE_DATE":
+		key = func(receipt *lkdr.Receipt) time.Time { return receipt.ReceiveDate.Time() }
+	case "CREATED_DATE":
+		key = func(receipt *lkdr.Receipt) time.Time { return receipt.CreatedDate.Time() }
+	default:
+		return fmt.Errorf("unsupported order field %s", field)
+	}
+
+	var desc bool
+	switch direction {
+	case "", "ASC":
+	case "DESC":
+		desc = true
+	default:
+		return fmt.Errorf("unsupported order direction %s", direction)
+	}
+
+	sort.SliceStable(receipts, func(i, j int) bool {
+		a, b := key(&receipts[i]), key(&receipts[j])
+		if desc {
+			return a.After(b)
+		}
+
+		return a.Before(b)
+	})
+
+	return nil
+}
+
+func decode(w http.ResponseWriter, r *http.Request, value any) bool {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, lkdr.Error{Message: "decode request body: " + err.Error()})
+		return false
+	}
+
+	return true
+}
+
+func writeFailure(w http.ResponseWriter, failure Failure) {
+	for key, values := range failure.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	status := failure.Status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	if failure.Error != nil {
+		writeError(w, status, *failure.Error)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(failure.Body)
+}
+
+func writeError(w http.ResponseWriter, status int, err lkdr.Error) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(err)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func randomToken() string {
+	var data [16]byte
+	_, _ = rand.Read(data[:])
+	return hex.EncodeToString(data[:])
+}
+
+func randomCode() string {
+	var data [3]byte
+	_, _ = rand.Read(data[:])
+	return fmt.Sprintf("%06d", (int(data[0])<<16|int(data[1])<<8|int(data[2]))%1000000)
+}
@@ -0,0 +1,335 @@
+package lkdrtest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+const phone = "79999999999"
+
+type response struct {
+	status int
+	body   []byte
+}
+
+func (r response) decode(t *testing.T, value any) {
+	require.NoError(t, json.Unmarshal(r.body, value), string(r.body))
+}
+
+func (r response) error(t *testing.T) lkdr.Error {
+	var err lkdr.Error
+	r.decode(t, &err)
+	return err
+}
+
+func post(t *testing.T, server *lkdrtest.Server, path, token string, body any) response {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, server.URL()+path, bytes.NewReader(data))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	require.NoError(t, err)
+	return response{status: resp.StatusCode, body: buf.Bytes()}
+}
+
+type challenge struct {
+	ChallengeToken             string                   `json:"challengeToken"`
+	ChallengeTokenExpiresIn    lkdr.DateTimeMilliOffset `json:"challengeTokenExpiresIn"`
+	ChallengeTokenExpiresInSec int                      `json:"challengeTokenExpiresInSec"`
+}
+
+func start(t *testing.T, server *lkdrtest.Server) challenge {
+	resp := post(t, server, lkdrtest.StartPath, "", map[string]any{"phone": phone, "captchaToken": "captcha"})
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	var out challenge
+	resp.decode(t, &out)
+	return out
+}
+
+func verify(t *testing.T, server *lkdrtest.Server, challengeToken, code string) response {
+	return post(t, server, lkdrtest.VerifyPath, "", map[string]any{
+		"phone":          phone,
+		"challengeToken": challengeToken,
+		"code":           code,
+	})
+}
+
+func TestServer_Challenge(t *testing.T) {
+	clock := lkdrtest.NewClock(time.Now())
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{Clock: clock, Code: "1234", ChallengeTTL: time.Minute})
+	defer server.Close()
+
+	t.Run("requires captcha token", func(t *testing.T) {
+		resp := post(t, server, lkdrtest.StartPath, "", map[string]any{"phone": phone})
+		assert.Equal(t, http.StatusBadRequest, resp.status)
+	})
+
+	out := start(t, server)
+	assert.NotEmpty(t, out.ChallengeToken)
+	assert.Equal(t, 60, out.ChallengeTokenExpiresInSec)
+	assert.WithinDuration(t, clock.Now().Add(time.Minute), out.ChallengeTokenExpiresIn.Time(), time.Millisecond)
+
+	code, ok := server.Code(phone)
+	require.True(t, ok)
+	assert.Equal(t, "1234", code)
+
+	t.Run("rejects start while challenge is active", func(t *testing.T) {
+		resp := post(t, server, lkdrtest.StartPath, "", map[string]any{"phone": phone, "captchaToken": "captcha"})
+		assert.Equal(t, http.StatusBadRequest, resp.status)
+		assert.Equal(t, lkdr.SmsVerificationNotExpired, resp.error(t).Code)
+	})
+
+	t.Run("starts new challenge after expiry", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		_, ok := server.Code(phone)
+		assert.False(t, ok)
+
+		next := start(t, server)
+		assert.NotEqual(t, out.ChallengeToken, next.ChallengeToken)
+	})
+}
+
+func TestServer_Verify(t *testing.T) {
+	clock := lkdrtest.NewClock(time.Now())
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{Clock: clock, Code: "1234", ChallengeTTL: time.Minute})
+	defer server.Close()
+
+	t.Run("rejects unknown challenge", func(t *testing.T) {
+		resp := verify(t, server, "unknown", "1234")
+		assert.Equal(t, http.StatusBadRequest, resp.status)
+		assert.Empty(t, resp.error(t).Code)
+	})
+
+	t.Run("issues tokens for valid code", func(t *testing.T) {
+		out := start(t, server)
+		resp := verify(t, server, out.ChallengeToken, "1234")
+		require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+		var tokens lkdr.Tokens
+		resp.decode(t, &tokens)
+		assert.NotEmpty(t, tokens.Token)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.True(t, tokens.TokenExpireIn.Time().After(clock.Now()))
+
+		resp = verify(t, server, out.ChallengeToken, "1234")
+		assert.Equal(t, http.StatusBadRequest, resp.status, "challenge is consumed")
+	})
+
+	t.Run("drops challenge after too many attempts", func(t *testing.T) {
+		out := start(t, server)
+		for range 3 {
+			resp := verify(t, server, out.ChallengeToken, "0000")
+			assert.Equal(t, http.StatusBadRequest, resp.status)
+		}
+
+		resp := verify(t, server, out.ChallengeToken, "1234")
+		assert.Equal(t, http.StatusBadRequest, resp.status)
+	})
+
+	t.Run("rejects expired challenge", func(t *testing.T) {
+		out := start(t, server)
+		clock.Advance(time.Minute)
+		resp := verify(t, server, out.ChallengeToken, "1234")
+		assert.Equal(t, http.StatusBadRequest, resp.status)
+	})
+}
+
+func TestServer_Token(t *testing.T) {
+	clock := lkdrtest.NewClock(time.Now())
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{Clock: clock, TokenTTL: time.Hour, RefreshTokenTTL: 24 * time.Hour})
+	defer server.Close()
+
+	refresh := func(refreshToken string) response {
+		return post(t, server, lkdrtest.TokenPath, "", map[string]any{"refreshToken": refreshToken})
+	}
+
+	t.Run("rotates refresh token", func(t *testing.T) {
+		issued := server.IssueTokens(phone)
+		resp := refresh(issued.RefreshToken)
+		require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+		var tokens lkdr.Tokens
+		resp.decode(t, &tokens)
+		assert.NotEqual(t, issued.Token, tokens.Token)
+		assert.NotEqual(t, issued.RefreshToken, tokens.RefreshToken)
+
+		resp = refresh(issued.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, resp.status)
+	})
+
+	t.Run("rejects expired refresh token", func(t *testing.T) {
+		issued := server.IssueTokens(phone)
+		clock.Advance(24 * time.Hour)
+		resp := refresh(issued.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, resp.status)
+	})
+
+	t.Run("expire keeps refresh token", func(t *testing.T) {
+		issued := server.IssueTokens(phone)
+		server.ExpireTokens(phone)
+		resp := post(t, server, lkdrtest.ReceiptPath, issued.Token, lkdr.ReceiptIn{})
+		assert.Equal(t, http.StatusUnauthorized, resp.status)
+		assert.Equal(t, http.StatusOK, refresh(issued.RefreshToken).status)
+	})
+
+	t.Run("revoke drops refresh token", func(t *testing.T) {
+		issued := server.IssueTokens(phone)
+		server.RevokeTokens(phone)
+		assert.Equal(t, http.StatusUnauthorized, refresh(issued.RefreshToken).status)
+	})
+}
+
+func TestServer_Receipt(t *testing.T) {
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+
+	date := func(day int) lkdr.DateTime {
+		return lkdr.DateTime(time.Date(2024, 5, day, 12, 0, 0, 0, moscow))
+	}
+
+	brandID := int64(1)
+	server.AddBrands(lkdr.Brand{Id: brandID, Name: "brand"}, lkdr.Brand{Id: 2, Name: "unused"})
+	server.AddReceipts(phone,
+		lkdr.Receipt{Key: "a", BrandId: &brandID, KktOwner: "Shop", CreatedDate: date(1), ReceiveDate: date(3)},
+		lkdr.Receipt{Key: "b", KktOwner: "Cafe", CreatedDate: date(2), ReceiveDate: date(2)},
+		lkdr.Receipt{Key: "c", KktOwner: "Shop", CreatedDate: date(3), ReceiveDate: date(1)},
+	)
+
+	server.AddReceipts("70000000000", lkdr.Receipt{Key: "other", CreatedDate: date(1), ReceiveDate: date(1)})
+	token := server.IssueTokens(phone).Token
+
+	receipts := func(t *testing.T, in lkdr.ReceiptIn) lkdr.ReceiptOut {
+		resp := post(t, server, lkdrtest.ReceiptPath, token, in)
+		require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+		var out lkdr.ReceiptOut
+		resp.decode(t, &out)
+		return out
+	}
+
+	keys := func(out lkdr.ReceiptOut) []string {
+		var keys []string
+		for _, receipt := range out.Receipts {
+			keys = append(keys, receipt.Key)
+		}
+
+		return keys
+	}
+
+	t.Run("requires valid token", func(t *testing.T) {
+		resp := post(t, server, lkdrtest.ReceiptPath, "invalid", lkdr.ReceiptIn{})
+		assert.Equal(t, http.StatusUnauthorized, resp.status)
+	})
+
+	t.Run("orders by receive date descending by default", func(t *testing.T) {
+		out := receipts(t, lkdr.ReceiptIn{})
+		assert.Equal(t, []string{"a", "b", "c"}, keys(out))
+		assert.False(t, out.HasMore)
+		assert.Equal(t, []lkdr.Brand{{Id: brandID, Name: "brand"}}, out.Brands)
+	})
+
+	t.Run("orders by created date", func(t *testing.T) {
+		out := receipts(t, lkdr.ReceiptIn{OrderBy: "CREATED_DATE:DESC"})
+		assert.Equal(t, []string{"c", "b", "a"}, keys(out))
+	})
+
+	t.Run("paginates", func(t *testing.T) {
+		out := receipts(t, lkdr.ReceiptIn{Limit: 2})
+		assert.Equal(t, []string{"a", "b"}, keys(out))
+		assert.True(t, out.HasMore)
+
+		out = receipts(t, lkdr.ReceiptIn{Limit: 2, Offset: 2})
+		assert.Equal(t, []string{"c"}, keys(out))
+		assert.False(t, out.HasMore)
+		assert.Empty(t, out.Brands)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		out := receipts(t, lkdr.ReceiptIn{KktOwner: "shop"})
+		assert.Equal(t, []string{"a", "c"}, keys(out))
+
+		from, to := lkdr.Date(date(2).Time()), lkdr.Date(date(2).Time())
+		out = receipts(t, lkdr.ReceiptIn{DateFrom: &from, DateTo: &to})
+		assert.Equal(t, []string{"b"}, keys(out))
+	})
+
+	t.Run("rejects unsupported order", func(t *testing.T) {
+		resp := post(t, server, lkdrtest.ReceiptPath, token, lkdr.ReceiptIn{OrderBy: "KEY:ASC"})
+		assert.Equal(t, http.StatusBadRequest, resp.status)
+	})
+}
+
+func TestServer_FiscalData(t *testing.T) {
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	token := server.IssueTokens(phone).Token
+	server.SetFiscalData("key", &lkdr.FiscalDataOut{TotalSum: 10, KktRegId: "kkt"})
+
+	t.Run("requires valid token", func(t *testing.T) {
+		resp := post(t, server, lkdrtest.FiscalDataPath, "invalid", lkdr.FiscalDataIn{Key: "key"})
+		assert.Equal(t, http.StatusUnauthorized, resp.status)
+	})
+
+	t.Run("returns fiscal data", func(t *testing.T) {
+		resp := post(t, server, lkdrtest.FiscalDataPath, token, lkdr.FiscalDataIn{Key: "key"})
+		require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+		var out lkdr.FiscalDataOut
+		resp.decode(t, &out)
+		assert.Equal(t, 10.0, out.TotalSum)
+		assert.Equal(t, "kkt", out.KktRegId)
+	})
+
+	t.Run("reports missing fiscal data", func(t *testing.T) {
+		server.SetFiscalData("key", nil)
+		resp := post(t, server, lkdrtest.FiscalDataPath, token, lkdr.FiscalDataIn{Key: "key"})
+		assert.Equal(t, http.StatusBadRequest, resp.status)
+		assert.Equal(t, lkdr.ReceiptFiscalDataNotFound, resp.error(t).Code)
+	})
+}
+
+func TestServer_Fail(t *testing.T) {
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	token := server.IssueTokens(phone).Token
+	server.Fail(lkdrtest.ReceiptPath,
+		lkdrtest.Failure{Status: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}},
+		lkdrtest.Failure{Status: http.StatusInternalServerError, Error: &lkdr.Error{Message: "boom"}},
+	)
+
+	resp := post(t, server, lkdrtest.ReceiptPath, token, lkdr.ReceiptIn{})
+	assert.Equal(t, http.StatusTooManyRequests, resp.status)
+
+	resp = post(t, server, lkdrtest.ReceiptPath, token, lkdr.ReceiptIn{})
+	assert.Equal(t, http.StatusInternalServerError, resp.status)
+	assert.Equal(t, "boom", resp.error(t).Message)
+
+	resp = post(t, server, lkdrtest.ReceiptPath, token, lkdr.ReceiptIn{})
+	assert.Equal(t, http.StatusOK, resp.status)
+	assert.Equal(t, 3, server.Requests(lkdrtest.ReceiptPath))
+}
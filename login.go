@@ -0,0 +1,211 @@
+package lkdr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StartLogin starts interactive login with a solved captcha token, sending SMS code to the phone.
+// If there is an active challenge already, it is returned instead and no SMS is sent.
+// The server does not allow to request another code before the challenge expires,
+// so in order to resend the code StartLogin should be called again after that.
+// The returned Challenge should be passed to VerifyLogin along with the received code before it expires.
+func (c *Client) StartLogin(ctx context.Context, captchaToken string) (*Challenge, error) {
+	challenge, err := c.challenges.LoadChallenge(ctx, c.phone)
+	if err != nil {
+		return nil, errors.Wrap(err, "load challenge")
+	}
+
+	if challenge != nil && c.clock.Now().Before(challenge.ExpiresIn.Time()) {
+		return challenge, nil
+	}
+
+	challenge, err = c.start(ctx, captchaToken)
+	if err != nil {
+		if code, _ := ErrorCodeOf(err); code == SmsVerificationNotExpired {
+			return nil, errors.Wrap(err, "previous challenge is still active, but its token is unknown")
+		}
+
+		return nil, errors.Wrap(err, "start sms challenge")
+	}
+
+	return challenge, nil
+}
+
+// VerifyLogin completes interactive login with the code received via SMS.
+// Tokens are persisted in TokenStorage under TokenLocker, if set, and used for subsequent requests.
+// ErrChallengeExpired is returned if the challenge has expired, in which case StartLogin should be called again.
+// If the code is rejected, the error is a ChallengeError (see IsChallengeError) and another code may be tried.
+func (c *Client) VerifyLogin(ctx context.Context, challenge *Challenge, code string) (*Tokens, error) {
+	ctx, cancel := c.tokenMu.Lock(ctx)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, unlock, err := c.lockTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	tokens, err := c.verify(ctx, challenge, code)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err = c.updateTokens(ctx, tokens)
+	if err != nil {
+		return nil, errors.Wrap(err, "update token")
+	}
+
+	return tokens, nil
+}
+
+func (c *Client) authorize(ctx context.Context) (*Tokens, error) {
+	authorizer, err := c.authorizer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := c.challenge(ctx, authorizer)
+	if err != nil {
+		return nil, errors.Wrap(err, "start sms challenge")
+	}
+
+	code, err := authorizer.GetConfirmationCode(ctx, c.phone)
+	if err != nil {
+		return nil, errors.Wrap(err, "get confirmation code")
+	}
+
+	return c.verify(ctx, challenge, code)
+}
+
+// authorizer returns Authorizer from the context or the default one.
+func (c *Client) authorizer(ctx context.Context) (Authorizer, error) {
+	if c.nonInteractive || isNonInteractive(ctx) {
+		return nil, errors.Wrap(ErrReauthRequired, "non-interactive mode")
+	}
+
+	authorizer := getAuthorizer(ctx)
+	if authorizer == nil {
+		authorizer = c.defaultAuthorizer
+	}
+
+	if authorizer == nil {
+		return nil, errors.Wrap(ErrReauthRequired, "authorizer is not set")
+	}
+
+	return authorizer, nil
+}
+
+// challenge returns the active SMS challenge, starting a new one if there is none.
+func (c *Client) challenge(ctx context.Context, authorizer Authorizer) (*Challenge, error) {
+	challenge, err := c.challenges.LoadChallenge(ctx, c.phone)
+	if err != nil {
+		return nil, errors.Wrap(err, "load challenge")
+	}
+
+	if challenge != nil && c.clock.Now().Before(challenge.ExpiresIn.Time()) {
+		return challenge, nil
+	}
+
+	challenge, err = c.startChallenge(ctx, authorizer)
+	if err != nil {
+		if code, _ := ErrorCodeOf(err); code == SmsVerificationNotExpired {
+			return nil, errors.Wrap(err, "previous challenge is still active, but its token is unknown")
+		}
+
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// startChallenge requests SMS challenge, solving captcha again if the server rejects it.
+// Rejected captcha tokens are reported to the authorizer if it implements BadCaptchaReporter.
+func (c *Client) startChallenge(ctx context.Context, authorizer Authorizer) (*Challenge, error) {
+	reporter, _ := authorizer.(BadCaptchaReporter)
+	for attempt := 1; ; attempt++ {
+		captchaToken, err := authorizer.GetCaptchaToken(ctx, c.deviceInfo.MetaDetails.UserAgent, captchaSiteKey, captchaPageURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "get captcha token")
+		}
+
+		challenge, err := c.start(ctx, captchaToken)
+		if !IsCaptchaBlocked(err) {
+			return challenge, err
+		}
+
+		if reporter != nil {
+			// Failing to claim a refund should not prevent authorization.
+			_ = reporter.ReportBadCaptcha(ctx, captchaToken)
+		}
+
+		if attempt >= c.captchaAttempts {
+			return nil, errors.Wrapf(err, "captcha rejected %d times", attempt)
+		}
+	}
+}
+
+// start requests SMS challenge and persists it in ChallengeStorage.
+func (c *Client) start(ctx context.Context, captchaToken string) (*Challenge, error) {
+	startIn := &startIn{
+		DeviceInfo:   c.deviceInfo,
+		Phone:        c.phone,
+		CaptchaToken: captchaToken,
+	}
+
+	startOut, err := execute(ctx, c, startIn)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := &Challenge{
+		Token:     startOut.ChallengeToken,
+		ExpiresIn: startOut.ChallengeTokenExpiresIn,
+	}
+
+	if challenge.ExpiresIn.Time().IsZero() {
+		challenge.ExpiresIn = DateTimeMilliOffset(c.clock.Now().Add(time.Duration(startOut.ChallengeTokenExpiresInSec) * time.Second))
+	}
+
+	if err := c.challenges.UpdateChallenge(ctx, c.phone, challenge); err != nil {
+		return nil, errors.Wrap(err, "store challenge")
+	}
+
+	return challenge, nil
+}
+
+// verify exchanges the code for tokens. The stored challenge is reset on success.
+// On failure it is kept until it expires, so that another code may be tried.
+func (c *Client) verify(ctx context.Context, challenge *Challenge, code string) (*Tokens, error) {
+	if challenge == nil {
+		return nil, errors.New("challenge is required")
+	}
+
+	if expiresAt := challenge.ExpiresIn.Time(); !expiresAt.IsZero() && !c.clock.Now().Before(expiresAt) {
+		return nil, ErrChallengeExpired
+	}
+
+	verifyIn := &verifyIn{
+		DeviceInfo:     c.deviceInfo,
+		Phone:          c.phone,
+		ChallengeToken: challenge.Token,
+		Code:           code,
+	}
+
+	tokens, err := execute(ctx, c, verifyIn)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify code")
+	}
+
+	if err := c.challenges.UpdateChallenge(ctx, c.phone, nil); err != nil {
+		return nil, errors.Wrap(err, "reset challenge")
+	}
+
+	return tokens, nil
+}
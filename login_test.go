@@ -0,0 +1,80 @@
+package lkdr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+func TestClient_StartLogin(t *testing.T) {
+	ctx := context.Background()
+	clock := lkdrtest.NewClock(time.Now())
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{Clock: clock, ChallengeTTL: time.Minute})
+	defer server.Close()
+
+	client, err := lkdr.NewClient(lkdr.ClientParams{
+		Phone:        phone,
+		Clock:        clock,
+		DeviceID:     "device",
+		UserAgent:    "test",
+		TokenStorage: newMemoryStorage(),
+		BaseURL:      server.URL(),
+		RateLimiter:  lkdr.RateLimiterFunc(func(context.Context, string) error { return nil }),
+	})
+
+	require.NoError(t, err)
+
+	first, err := client.StartLogin(ctx, "captcha")
+	require.NoError(t, err)
+
+	t.Run("returns active challenge", func(t *testing.T) {
+		second, err := client.StartLogin(ctx, "captcha")
+		require.NoError(t, err)
+		assert.Equal(t, first.Token, second.Token)
+		assert.Equal(t, 1, server.Requests(lkdrtest.StartPath))
+	})
+
+	t.Run("reports active challenge with unknown token", func(t *testing.T) {
+		_, err := newClient(t, server, newMemoryStorage(), nil).StartLogin(ctx, "captcha")
+		code, ok := lkdr.ErrorCodeOf(err)
+		assert.True(t, ok)
+		assert.Equal(t, lkdr.SmsVerificationNotExpired, code)
+	})
+
+	t.Run("starts new challenge after expiry", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		_, err := client.VerifyLogin(ctx, first, "0000")
+		assert.ErrorIs(t, err, lkdr.ErrChallengeExpired)
+		assert.True(t, lkdr.IsChallengeError(err))
+
+		second, err := client.StartLogin(ctx, "captcha")
+		require.NoError(t, err)
+		assert.NotEqual(t, first.Token, second.Token)
+		first = second
+	})
+
+	t.Run("verifies code", func(t *testing.T) {
+		_, err := client.VerifyLogin(ctx, nil, "0000")
+		assert.Error(t, err)
+
+		_, err = client.VerifyLogin(ctx, first, "0000")
+		assert.True(t, lkdr.IsChallengeError(err), "invalid code is a challenge error")
+		assert.NotErrorIs(t, err, lkdr.ErrChallengeExpired)
+
+		code, ok := server.Code(phone)
+		require.True(t, ok)
+		tokens, err := client.VerifyLogin(ctx, first, code)
+		require.NoError(t, err)
+		assert.NotEmpty(t, tokens.Token)
+
+		session, err := client.Session(ctx)
+		require.NoError(t, err)
+		assert.True(t, session.LoggedIn)
+	})
+}
@@ -0,0 +1,284 @@
+package lkdr
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+type ManagerParams struct {
+	Clock        based.Clock  `validate:"required"`
+	DeviceID     string       `validate:"required"`
+	UserAgent    string       `validate:"required"`
+	TokenStorage TokenStorage `validate:"required"`
+
+	// Phones are the accounts known in advance. Other accounts are added on first use.
+	Phones []string
+
+	// Transport is shared by all clients.
+	Transport   http.RoundTripper
+	BaseURL     string
+	RetryPolicy *RetryPolicy
+
+	Authorizer      Authorizer
+	NonInteractive  bool
+	TokenLocker     TokenLocker
+	CaptchaAttempts int
+
+	// RateLimiter is shared by all clients. Defaults to TokenBucket with DefaultBudget.
+	RateLimiter RateLimiter
+}
+
+// Manager lazily creates and caches Clients for multiple accounts.
+// All clients share one transport, TokenStorage and RateLimiter.
+type Manager struct {
+	params  ManagerParams
+	phones  []string
+	clients map[string]*Client
+	limiter RateLimiter
+	mu      sync.Mutex
+}
+
+func NewManager(params ManagerParams) (*Manager, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	limiter := params.RateLimiter
+	if limiter == nil {
+		var err error
+		limiter, err = NewTokenBucket(TokenBucketParams{
+			Clock: params.Clock,
+			Total: DefaultBudget,
+		})
+
+		if err != nil {
+			return nil, errors.Wrap(err, "create rate limiter")
+		}
+	}
+
+	m := &Manager{
+		params:  params,
+		clients: make(map[string]*Client),
+		limiter: limiter,
+	}
+
+	for _, phone := range params.Phones {
+		m.addPhone(phone)
+	}
+
+	return m, nil
+}
+
+// Phones returns all known accounts in the order they were added.
+func (m *Manager) Phones() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.phones)
+}
+
+// Client returns the cached Client for the phone, creating it if needed.
+func (m *Manager) Client(phone string) (*Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if client, ok := m.clients[phone]; ok {
+		return client, nil
+	}
+
+	client, err := NewClient(ClientParams{
+		Phone:           phone,
+		Clock:           m.params.Clock,
+		DeviceID:        m.params.DeviceID,
+		UserAgent:       m.params.UserAgent,
+		TokenStorage:    m.params.TokenStorage,
+		Transport:       m.params.Transport,
+		BaseURL:         m.params.BaseURL,
+		RetryPolicy:     m.params.RetryPolicy,
+		Authorizer:      m.params.Authorizer,
+		NonInteractive:  m.params.NonInteractive,
+		TokenLocker:     m.params.TokenLocker,
+		CaptchaAttempts: m.params.CaptchaAttempts,
+		RateLimiter:     m.limiter,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.clients[phone] = client
+	m.addPhoneLocked(phone)
+	return client, nil
+}
+
+func (m *Manager) addPhone(phone string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addPhoneLocked(phone)
+}
+
+func (m *Manager) addPhoneLocked(phone string) {
+	if !slices.Contains(m.phones, phone) {
+		m.phones = append(m.phones, phone)
+	}
+}
+
+// AccountError is an error related to a specific account.
+type AccountError struct {
+	Phone string
+	Err   error
+}
+
+func (e *AccountError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Phone, e.Err)
+}
+
+func (e *AccountError) Unwrap() error {
+	return e.Err
+}
+
+// AccountStatus is the Session of an account.
+// Err is set if the session state could not be loaded.
+type AccountStatus struct {
+	Phone   string
+	Session *Session
+	Err     error
+}
+
+// Status returns session state of all known accounts without triggering authorization or refresh.
+func (m *Manager) Status(ctx context.Context) []AccountStatus {
+	phones := m.Phones()
+	statuses := make([]AccountStatus, len(phones))
+	for i, phone := range phones {
+		statuses[i].Phone = phone
+		client, err := m.Client(phone)
+		if err != nil {
+			statuses[i].Err = err
+			continue
+		}
+
+		statuses[i].Session, statuses[i].Err = client.Session(ctx)
+	}
+
+	return statuses
+}
+
+// AccountReceipt is a receipt belonging to an account.
+type AccountReceipt struct {
+	Phone string
+	ReceiptWithBrand
+}
+
+func (r *AccountReceipt) UnmarshalJSON(data []byte) error {
+	var wrapper struct{ Phone string }
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+
+	r.Phone = wrapper.Phone
+	return r.ReceiptWithBrand.UnmarshalJSON(data)
+}
+
+type ManagerReceiptOut struct {
+	// Receipts from all accounts ordered according to ReceiptIn.OrderBy.
+	Receipts []AccountReceipt
+
+	// HasMore lists accounts which have more receipts matching the filter.
+	HasMore []string
+}
+
+// Receipt executes the query for all known accounts concurrently and merges the results.
+// Limit and Offset are applied to each account separately.
+// If some of the queries fail, receipts from the other accounts are returned along with
+// the joined AccountErrors.
+func (m *Manager) Receipt(ctx context.Context, in *ReceiptIn) (*ManagerReceiptOut, error) {
+	var (
+		phones = m.Phones()
+		outs   = make([]*ReceiptOut, len(phones))
+		errs   = make([]error, len(phones))
+		wg     sync.WaitGroup
+	)
+
+	for i, phone := range phones {
+		client, err := m.Client(phone)
+		if err != nil {
+			errs[i] = &AccountError{Phone: phone, Err: err}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := client.Receipt(ctx, in)
+			if err != nil {
+				errs[i] = &AccountError{Phone: phone, Err: err}
+				return
+			}
+
+			outs[i] = out
+		}()
+	}
+
+	wg.Wait()
+
+	merged := new(ManagerReceiptOut)
+	for i, out := range outs {
+		if out == nil {
+			continue
+		}
+
+		brands := make(map[int64]Brand, len(out.Brands))
+		for _, brand := range out.Brands {
+			brands[brand.Id] = brand
+		}
+
+		for _, receipt := range out.Receipts {
+			entry := AccountReceipt{Phone: phones[i], ReceiptWithBrand: ReceiptWithBrand{Receipt: receipt}}
+			if receipt.BrandId != nil {
+				if brand, ok := brands[*receipt.BrandId]; ok {
+					entry.Brand = &brand
+				}
+			}
+
+			merged.Receipts = append(merged.Receipts, entry)
+		}
+
+		if out.HasMore {
+			merged.HasMore = append(merged.HasMore, phones[i])
+		}
+	}
+
+	var orderBy string
+	if in != nil {
+		orderBy = in.OrderBy
+	}
+
+	slices.SortStableFunc(merged.Receipts, receiptOrder(orderBy))
+	return merged, stderrors.Join(errs...)
+}
+
+// receiptOrder returns comparison function for ReceiptIn.OrderBy value ("RECEIVE_DATE:DESC" by default).
+func receiptOrder(orderBy string) func(a, b AccountReceipt) int {
+	field, direction, _ := strings.Cut(orderBy, ":")
+	date := func(r AccountReceipt) time.Time { return r.ReceiveDate.Time() }
+	if field == "CREATED_DATE" {
+		date = func(r AccountReceipt) time.Time { return r.CreatedDate.Time() }
+	}
+
+	sign := -1
+	if direction == "ASC" {
+		sign = 1
+	}
+
+	return func(a, b AccountReceipt) int {
+		return sign * date(a).Compare(date(b))
+	}
+}
@@ -0,0 +1,271 @@
+package lkdr
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPendingFiscalMaxAge   = 7 * 24 * time.Hour
+	defaultPendingFiscalInterval = time.Minute
+)
+
+// DefaultPendingFiscalSchedule is the default delay before each poll of a pending key.
+// The last delay is repeated until MaxAge is reached.
+var DefaultPendingFiscalSchedule = []time.Duration{
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+type PendingFiscalQueueParams struct {
+	Client *Client     `validate:"required"`
+	Clock  based.Clock `validate:"required"`
+
+	// OnFiscalData is called once fiscal data for a pending key appears.
+	// If it fails, the key is kept and fetched again on next poll.
+	OnFiscalData func(ctx context.Context, key string, data *FiscalDataOut) error `validate:"required"`
+
+	// OnGiveUp is called when a key is dropped after MaxAge.
+	OnGiveUp func(ctx context.Context, key string)
+
+	// OnError is called when background polling fails.
+	OnError func(ctx context.Context, err error)
+
+	// Schedule is the delay before each poll. Defaults to DefaultPendingFiscalSchedule.
+	Schedule []time.Duration
+
+	// MaxAge is the time after which pending keys are dropped. Defaults to 7 days.
+	MaxAge time.Duration
+
+	// Interval between due key checks in background loop. Defaults to 1 minute.
+	Interval time.Duration
+
+	// Workers limits the number of concurrent requests during a poll.
+	Workers int
+}
+
+// PendingFiscal is a receipt key with fiscal data not yet available.
+type PendingFiscal struct {
+	Key        string
+	AddedAt    time.Time
+	NextPollAt time.Time
+	Attempts   int
+}
+
+// PendingFiscalQueue re-polls fiscal data for keys which returned ReceiptFiscalDataNotFound,
+// which is common for fresh receipts whose data has not yet propagated from the fiscal data operator.
+//
+// Pending keys are kept in memory only. In order to survive restarts, persist the Pending snapshot
+// and pass it to Restore on startup, otherwise pending keys are lost without OnGiveUp being called.
+type PendingFiscalQueue struct {
+	client       *Client
+	clock        based.Clock
+	onFiscalData func(ctx context.Context, key string, data *FiscalDataOut) error
+	onGiveUp     func(ctx context.Context, key string)
+	onError      func(ctx context.Context, err error)
+	schedule     []time.Duration
+	maxAge       time.Duration
+	interval     time.Duration
+	workers      int
+	pending      map[string]*PendingFiscal
+	mu           sync.Mutex
+}
+
+func NewPendingFiscalQueue(params PendingFiscalQueueParams) (*PendingFiscalQueue, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	schedule := params.Schedule
+	if len(schedule) == 0 {
+		schedule = DefaultPendingFiscalSchedule
+	}
+
+	maxAge := params.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultPendingFiscalMaxAge
+	}
+
+	interval := params.Interval
+	if interval <= 0 {
+		interval = defaultPendingFiscalInterval
+	}
+
+	return &PendingFiscalQueue{
+		client:       params.Client,
+		clock:        params.Clock,
+		onFiscalData: params.OnFiscalData,
+		onGiveUp:     params.OnGiveUp,
+		onError:      params.OnError,
+		schedule:     schedule,
+		maxAge:       maxAge,
+		interval:     interval,
+		workers:      params.Workers,
+		pending:      make(map[string]*PendingFiscal),
+	}, nil
+}
+
+// Add records the key to be polled. Keys which are already pending are ignored.
+func (q *PendingFiscalQueue) Add(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[key]; ok {
+		return
+	}
+
+	now := q.clock.Now()
+	q.pending[key] = &PendingFiscal{
+		Key:        key,
+		AddedAt:    now,
+		NextPollAt: now.Add(q.delay(0)),
+	}
+}
+
+// Restore adds previously pending keys, e.g. from a Pending snapshot persisted before restart.
+// AddedAt and Attempts are preserved, so MaxAge and Schedule are applied as if the queue was never stopped.
+// Zero AddedAt is replaced with the current time, zero NextPollAt is calculated from Attempts.
+// Keys which are already pending are ignored.
+func (q *PendingFiscalQueue) Restore(entries ...PendingFiscal) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	for _, entry := range entries {
+		if _, ok := q.pending[entry.Key]; ok {
+			continue
+		}
+
+		if entry.AddedAt.IsZero() {
+			entry.AddedAt = now
+		}
+
+		if entry.NextPollAt.IsZero() {
+			entry.NextPollAt = now.Add(q.delay(entry.Attempts))
+		}
+
+		q.pending[entry.Key] = &entry
+	}
+}
+
+// Pending returns a snapshot of pending keys.
+func (q *PendingFiscalQueue) Pending() []PendingFiscal {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := make([]PendingFiscal, 0, len(q.pending))
+	for _, entry := range q.pending {
+		pending = append(pending, *entry)
+	}
+
+	return pending
+}
+
+// Poll fetches fiscal data for due keys and returns the number of keys delivered to OnFiscalData.
+// Keys older than MaxAge are dropped. Failed keys are rescheduled, and their errors are returned joined.
+func (q *PendingFiscalQueue) Poll(ctx context.Context) (int, error) {
+	due, expired := q.due()
+	for _, key := range expired {
+		if q.onGiveUp != nil {
+			q.onGiveUp(ctx, key)
+		}
+	}
+
+	var (
+		delivered int
+		errs      []error
+	)
+
+	for result := range q.client.FiscalDataBatch(ctx, due, &FiscalDataBatchOptions{Workers: q.workers}) {
+		switch {
+		case result.Data != nil:
+			if err := q.onFiscalData(ctx, result.Key, result.Data); err != nil {
+				q.reschedule(result.Key)
+				errs = append(errs, errors.Wrapf(err, "handle %s", result.Key))
+				continue
+			}
+
+			q.remove(result.Key)
+			delivered++
+		case result.NotFound:
+			q.reschedule(result.Key)
+		default:
+			q.reschedule(result.Key)
+			errs = append(errs, errors.Wrapf(result.Err, "get fiscal data for %s", result.Key))
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return delivered, err
+	}
+
+	return delivered, stderrors.Join(errs...)
+}
+
+// Start starts a background loop polling due keys every Interval.
+func (q *PendingFiscalQueue) Start(ctx context.Context) based.Goroutine {
+	return based.Go(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := q.Poll(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if q.onError != nil {
+					q.onError(ctx, err)
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// due returns keys which should be polled now and removes expired ones.
+func (q *PendingFiscalQueue) due() (due, expired []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	for key, entry := range q.pending {
+		switch {
+		case now.Sub(entry.AddedAt) > q.maxAge:
+			delete(q.pending, key)
+			expired = append(expired, key)
+		case !now.Before(entry.NextPollAt):
+			due = append(due, key)
+		}
+	}
+
+	return
+}
+
+func (q *PendingFiscalQueue) reschedule(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if entry, ok := q.pending[key]; ok {
+		entry.Attempts++
+		entry.NextPollAt = q.clock.Now().Add(q.delay(entry.Attempts))
+	}
+}
+
+func (q *PendingFiscalQueue) remove(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, key)
+}
+
+func (q *PendingFiscalQueue) delay(attempt int) time.Duration {
+	return q.schedule[min(attempt, len(q.schedule)-1)]
+}
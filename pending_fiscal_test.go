@@ -0,0 +1,156 @@
+package lkdr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+func TestPendingFiscalQueue(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	storage := newMemoryStorage()
+	require.NoError(t, storage.UpdateTokens(ctx, phone, server.IssueTokens(phone)))
+	client := newClient(t, server, storage, nil)
+
+	type queue struct {
+		*lkdr.PendingFiscalQueue
+		clock     *lkdrtest.Clock
+		delivered map[string]*lkdr.FiscalDataOut
+		givenUp   []string
+		fail      error
+	}
+
+	newQueue := func(t *testing.T, clock *lkdrtest.Clock) *queue {
+		q := &queue{
+			clock:     clock,
+			delivered: make(map[string]*lkdr.FiscalDataOut),
+		}
+
+		var err error
+		q.PendingFiscalQueue, err = lkdr.NewPendingFiscalQueue(lkdr.PendingFiscalQueueParams{
+			Client: client,
+			Clock:  q.clock,
+			OnFiscalData: func(_ context.Context, key string, data *lkdr.FiscalDataOut) error {
+				if q.fail != nil {
+					return q.fail
+				}
+
+				q.delivered[key] = data
+				return nil
+			},
+			OnGiveUp: func(_ context.Context, key string) {
+				q.givenUp = append(q.givenUp, key)
+			},
+			Schedule: []time.Duration{time.Minute, time.Hour},
+			MaxAge:   24 * time.Hour,
+		})
+
+		require.NoError(t, err)
+		return q
+	}
+
+	poll := func(t *testing.T, q *queue) int {
+		delivered, err := q.Poll(ctx)
+		require.NoError(t, err)
+		return delivered
+	}
+
+	t.Run("follows schedule", func(t *testing.T) {
+		server.SetFiscalData("a", nil)
+		q := newQueue(t, lkdrtest.NewClock(time.Now()))
+		q.Add("a")
+		requests := server.Requests(lkdrtest.FiscalDataPath)
+
+		assert.Zero(t, poll(t, q))
+		assert.Equal(t, requests, server.Requests(lkdrtest.FiscalDataPath), "key is not due yet")
+
+		q.clock.Advance(time.Minute)
+		assert.Zero(t, poll(t, q))
+		assert.Equal(t, requests+1, server.Requests(lkdrtest.FiscalDataPath))
+
+		pending := q.Pending()
+		require.Len(t, pending, 1)
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Equal(t, q.clock.Now().Add(time.Hour), pending[0].NextPollAt)
+
+		q.clock.Advance(time.Hour)
+		assert.Zero(t, poll(t, q))
+		pending = q.Pending()
+		require.Len(t, pending, 1)
+		assert.Equal(t, q.clock.Now().Add(time.Hour), pending[0].NextPollAt, "last delay is repeated")
+
+		server.SetFiscalData("a", &lkdr.FiscalDataOut{TotalSum: 1})
+		q.clock.Advance(time.Hour)
+		assert.Equal(t, 1, poll(t, q))
+		assert.Empty(t, q.Pending())
+		require.NotNil(t, q.delivered["a"])
+		assert.Equal(t, 1.0, q.delivered["a"].TotalSum)
+	})
+
+	t.Run("keeps key if handler fails", func(t *testing.T) {
+		server.SetFiscalData("b", &lkdr.FiscalDataOut{TotalSum: 2})
+		q := newQueue(t, lkdrtest.NewClock(time.Now()))
+		q.Add("b")
+		q.fail = errors.New("sink is unavailable")
+
+		q.clock.Advance(time.Minute)
+		delivered, err := q.Poll(ctx)
+		assert.ErrorIs(t, err, q.fail)
+		assert.Zero(t, delivered)
+		require.Len(t, q.Pending(), 1)
+
+		q.fail = nil
+		q.clock.Advance(time.Hour)
+		assert.Equal(t, 1, poll(t, q))
+		assert.Empty(t, q.Pending())
+	})
+
+	t.Run("gives up after max age", func(t *testing.T) {
+		server.SetFiscalData("c", nil)
+		q := newQueue(t, lkdrtest.NewClock(time.Now()))
+		q.Add("c")
+
+		q.clock.Advance(25 * time.Hour)
+		requests := server.Requests(lkdrtest.FiscalDataPath)
+		assert.Zero(t, poll(t, q))
+		assert.Equal(t, []string{"c"}, q.givenUp)
+		assert.Empty(t, q.Pending())
+		assert.Equal(t, requests, server.Requests(lkdrtest.FiscalDataPath))
+	})
+
+	t.Run("restores snapshot", func(t *testing.T) {
+		server.SetFiscalData("d", nil)
+		server.SetFiscalData("e", nil)
+		q := newQueue(t, lkdrtest.NewClock(time.Now()))
+		q.Add("d")
+		q.clock.Advance(time.Minute)
+		assert.Zero(t, poll(t, q))
+		snapshot := q.Pending()
+
+		restarted := newQueue(t, q.clock)
+		restarted.Restore(snapshot...)
+		restarted.Restore(lkdr.PendingFiscal{Key: "d"})
+		assert.Equal(t, snapshot, restarted.Pending(), "already pending keys are ignored")
+
+		restarted.Restore(lkdr.PendingFiscal{Key: "e", AddedAt: q.clock.Now().Add(-25 * time.Hour), Attempts: 3})
+		restarted.clock.Advance(time.Hour)
+		assert.Zero(t, poll(t, restarted))
+		assert.Equal(t, []string{"e"}, restarted.givenUp)
+
+		pending := restarted.Pending()
+		require.Len(t, pending, 1)
+		assert.Equal(t, "d", pending[0].Key)
+		assert.Equal(t, snapshot[0].AddedAt, pending[0].AddedAt)
+		assert.Equal(t, 2, pending[0].Attempts)
+	})
+}
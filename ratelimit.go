@@ -0,0 +1,247 @@
+package lkdr
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+// RateLimiter limits the rate of requests sent to the server.
+// Wait blocks until a request to the path may be sent or the context is done.
+// One RateLimiter may be shared by multiple clients.
+type RateLimiter interface {
+	Wait(ctx context.Context, path string) error
+}
+
+// RateLimitReporter may be implemented by RateLimiter to adapt to 429 Too Many Requests responses.
+// retryAfter is the delay requested by the server, or zero if unknown.
+type RateLimitReporter interface {
+	ReportRateLimited(path string, retryAfter time.Duration)
+}
+
+type RateLimiterFunc func(ctx context.Context, path string) error
+
+func (fn RateLimiterFunc) Wait(ctx context.Context, path string) error {
+	return fn(ctx, path)
+}
+
+// Budget is the number of requests allowed per period.
+type Budget struct {
+	Requests int
+	Per      time.Duration
+
+	// Burst is the number of requests which may be sent at once. Defaults to Requests.
+	Burst int
+}
+
+// DefaultBudget is the request budget used by clients unless RateLimiter is set.
+var DefaultBudget = Budget{Requests: 20, Per: time.Minute}
+
+func (b Budget) rate() float64 {
+	if b.Requests <= 0 || b.Per <= 0 {
+		return 0
+	}
+
+	return float64(b.Requests) / b.Per.Seconds()
+}
+
+func (b Budget) burst() float64 {
+	if b.Burst > 0 {
+		return float64(b.Burst)
+	}
+
+	return float64(max(b.Requests, 1))
+}
+
+type TokenBucketParams struct {
+	// Clock is used to refill buckets. It must track real time: Wait sleeps for the delays calculated
+	// with Clock using real timers, so with a clock which does not advance it blocks until the context is done.
+	Clock based.Clock `validate:"required"`
+
+	// Total is the budget shared by all endpoints. Zero value means no limit.
+	Total Budget
+
+	// Endpoints are budgets keyed by request path (e.g. "/v1/receipt/fiscal_data"), applied in addition to Total.
+	Endpoints map[string]Budget
+
+	// MaxSlowdown limits the factor the rate is divided by after 429 responses. Defaults to 16.
+	MaxSlowdown float64
+
+	// Recovery is the period without 429 responses after which slowdown is halved. Defaults to 1 minute.
+	Recovery time.Duration
+}
+
+// TokenBucket is a RateLimiter with token bucket per budget.
+// Each 429 response pauses requests for the Retry-After duration and halves the rates,
+// which are then restored gradually.
+type TokenBucket struct {
+	clock       based.Clock
+	total       *bucket
+	endpoints   map[string]*bucket
+	maxSlowdown float64
+	recovery    time.Duration
+	slowdown    float64
+	throttledAt time.Time
+	pausedUntil time.Time
+	mu          sync.Mutex
+}
+
+func NewTokenBucket(params TokenBucketParams) (*TokenBucket, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	maxSlowdown := params.MaxSlowdown
+	if maxSlowdown < 1 {
+		maxSlowdown = 16
+	}
+
+	recovery := params.Recovery
+	if recovery <= 0 {
+		recovery = time.Minute
+	}
+
+	now := params.Clock.Now()
+	endpoints := make(map[string]*bucket, len(params.Endpoints))
+	for path, budget := range params.Endpoints {
+		endpoints[path] = newBucket(budget, now)
+	}
+
+	return &TokenBucket{
+		clock:       params.Clock,
+		total:       newBucket(params.Total, now),
+		endpoints:   endpoints,
+		maxSlowdown: maxSlowdown,
+		recovery:    recovery,
+		slowdown:    1,
+	}, nil
+}
+
+func (tb *TokenBucket) Wait(ctx context.Context, path string) error {
+	for {
+		delay := tb.reserve(path)
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(ctx.Err(), "wait for rate limit")
+		}
+	}
+}
+
+func (tb *TokenBucket) ReportRateLimited(path string, retryAfter time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := tb.clock.Now()
+	tb.recover(now)
+	tb.slowdown = min(tb.slowdown*2, tb.maxSlowdown)
+	tb.throttledAt = now
+	if until := now.Add(retryAfter); until.After(tb.pausedUntil) {
+		tb.pausedUntil = until
+	}
+}
+
+// reserve takes a token from each matching bucket or returns the delay until tokens are available.
+func (tb *TokenBucket) reserve(path string) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := tb.clock.Now()
+	if now.Before(tb.pausedUntil) {
+		return tb.pausedUntil.Sub(now)
+	}
+
+	tb.recover(now)
+	buckets := []*bucket{tb.total}
+	if endpoint, ok := tb.endpoints[path]; ok {
+		buckets = append(buckets, endpoint)
+	}
+
+	var delay time.Duration
+	for _, b := range buckets {
+		delay = max(delay, b.delay(now, tb.slowdown))
+	}
+
+	if delay > 0 {
+		return delay
+	}
+
+	for _, b := range buckets {
+		b.take()
+	}
+
+	return 0
+}
+
+func (tb *TokenBucket) recover(now time.Time) {
+	for tb.slowdown > 1 && now.Sub(tb.throttledAt) >= tb.recovery {
+		tb.slowdown = max(tb.slowdown/2, 1)
+		tb.throttledAt = tb.throttledAt.Add(tb.recovery)
+	}
+}
+
+type bucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	updated  time.Time
+}
+
+func newBucket(budget Budget, now time.Time) *bucket {
+	return &bucket{
+		rate:     budget.rate(),
+		capacity: budget.burst(),
+		tokens:   budget.burst(),
+		updated:  now,
+	}
+}
+
+// delay refills the bucket and returns the time until a token is available.
+func (b *bucket) delay(now time.Time, slowdown float64) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+
+	rate := b.rate / slowdown
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*rate, b.capacity)
+		b.updated = now
+	}
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+func (b *bucket) take() {
+	if b.rate != 0 {
+		b.tokens--
+	}
+}
+
+// reportRateLimited reports 429 responses to the limiter. Other errors, including rejections
+// of a single request (e.g. too many SMS code attempts), must not slow down requests sharing the limiter.
+func reportRateLimited(limiter RateLimiter, clock based.Clock, path string, err error) {
+	reporter, ok := limiter.(RateLimitReporter)
+	if !ok {
+		return
+	}
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	retryDelay, _ := retryAfter(clock, httpErr.Header)
+	reporter.ReportRateLimited(path, retryDelay)
+}
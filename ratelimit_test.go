@@ -0,0 +1,127 @@
+package lkdr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+
+	newTokenBucket := func(t *testing.T, params lkdr.TokenBucketParams) (*lkdr.TokenBucket, *lkdrtest.Clock) {
+		clock := lkdrtest.NewClock(time.Now())
+		params.Clock = clock
+		tb, err := lkdr.NewTokenBucket(params)
+		require.NoError(t, err)
+		return tb, clock
+	}
+
+	// allowed reports whether a request to the path may be sent without waiting.
+	allowed := func(t *testing.T, tb *lkdr.TokenBucket, path string) bool {
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		return tb.Wait(ctx, path) == nil
+	}
+
+	t.Run("burst and refill", func(t *testing.T) {
+		tb, clock := newTokenBucket(t, lkdr.TokenBucketParams{Total: lkdr.Budget{Requests: 2, Per: time.Minute}})
+		assert.True(t, allowed(t, tb, "/a"))
+		assert.True(t, allowed(t, tb, "/b"))
+		assert.False(t, allowed(t, tb, "/a"))
+
+		clock.Advance(29 * time.Second)
+		assert.False(t, allowed(t, tb, "/a"))
+		clock.Advance(time.Second)
+		assert.True(t, allowed(t, tb, "/a"))
+		assert.False(t, allowed(t, tb, "/a"))
+
+		clock.Advance(time.Hour)
+		assert.True(t, allowed(t, tb, "/a"))
+		assert.True(t, allowed(t, tb, "/a"))
+		assert.False(t, allowed(t, tb, "/a"), "tokens are capped by burst")
+	})
+
+	t.Run("explicit burst", func(t *testing.T) {
+		tb, clock := newTokenBucket(t, lkdr.TokenBucketParams{Total: lkdr.Budget{Requests: 60, Per: time.Minute, Burst: 1}})
+		assert.True(t, allowed(t, tb, "/a"))
+		assert.False(t, allowed(t, tb, "/a"))
+		clock.Advance(time.Second)
+		assert.True(t, allowed(t, tb, "/a"))
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		tb, _ := newTokenBucket(t, lkdr.TokenBucketParams{})
+		for range 100 {
+			require.True(t, allowed(t, tb, "/a"))
+		}
+	})
+
+	t.Run("endpoint budget", func(t *testing.T) {
+		tb, clock := newTokenBucket(t, lkdr.TokenBucketParams{
+			Total:     lkdr.Budget{Requests: 3, Per: time.Minute},
+			Endpoints: map[string]lkdr.Budget{"/a": {Requests: 1, Per: time.Minute}},
+		})
+
+		assert.True(t, allowed(t, tb, "/a"))
+		assert.False(t, allowed(t, tb, "/a"))
+		assert.True(t, allowed(t, tb, "/b"))
+		assert.True(t, allowed(t, tb, "/b"))
+		assert.False(t, allowed(t, tb, "/b"), "endpoint requests are counted in total budget")
+
+		clock.Advance(time.Minute)
+		assert.True(t, allowed(t, tb, "/a"))
+	})
+
+	t.Run("slowdown and recovery", func(t *testing.T) {
+		tb, clock := newTokenBucket(t, lkdr.TokenBucketParams{
+			Total:    lkdr.Budget{Requests: 60, Per: time.Minute, Burst: 1},
+			Recovery: time.Minute,
+		})
+
+		assert.True(t, allowed(t, tb, "/a"))
+		tb.ReportRateLimited("/a", 10*time.Second)
+
+		clock.Advance(9 * time.Second)
+		assert.False(t, allowed(t, tb, "/a"), "requests are paused for Retry-After")
+		clock.Advance(time.Second)
+		assert.True(t, allowed(t, tb, "/a"))
+
+		clock.Advance(time.Second)
+		assert.False(t, allowed(t, tb, "/a"), "rate is halved")
+		clock.Advance(time.Second)
+		assert.True(t, allowed(t, tb, "/a"))
+
+		tb.ReportRateLimited("/a", 0)
+		clock.Advance(3 * time.Second)
+		assert.False(t, allowed(t, tb, "/a"), "rate is halved again")
+		clock.Advance(time.Second)
+		assert.True(t, allowed(t, tb, "/a"))
+
+		clock.Advance(2 * time.Minute)
+		assert.True(t, allowed(t, tb, "/a"))
+		clock.Advance(time.Second)
+		assert.True(t, allowed(t, tb, "/a"), "rate is restored")
+	})
+
+	t.Run("max slowdown", func(t *testing.T) {
+		tb, clock := newTokenBucket(t, lkdr.TokenBucketParams{
+			Total:       lkdr.Budget{Requests: 60, Per: time.Minute, Burst: 1},
+			MaxSlowdown: 2,
+		})
+
+		assert.True(t, allowed(t, tb, "/a"))
+		for range 5 {
+			tb.ReportRateLimited("/a", 0)
+		}
+
+		clock.Advance(2 * time.Second)
+		assert.True(t, allowed(t, tb, "/a"))
+	})
+}
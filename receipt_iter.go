@@ -0,0 +1,99 @@
+package lkdr
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+
+	"github.com/pkg/errors"
+)
+
+const defaultReceiptPageSize = 50
+
+// ReceiptWithBrand is a Receipt along with its Brand, if known.
+type ReceiptWithBrand struct {
+	Receipt
+	Brand *Brand
+}
+
+// UnmarshalJSON prevents Receipt.UnmarshalJSON from being promoted, which would leave Brand empty.
+func (r *ReceiptWithBrand) UnmarshalJSON(data []byte) error {
+	var wrapper struct{ Brand *Brand }
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+
+	r.Brand = wrapper.Brand
+	return r.Receipt.UnmarshalJSON(data)
+}
+
+// Receipts iterates over all receipts matching the filter, requesting pages until ReceiptOut.HasMore is false.
+// Limit is used as page size and Offset as the starting position.
+// Receipts which were already yielded are skipped, so that pages shifted by receipts arriving
+// mid-iteration do not produce duplicates.
+func (c *Client) Receipts(ctx context.Context, in *ReceiptIn) iter.Seq2[ReceiptWithBrand, error] {
+	return func(yield func(ReceiptWithBrand, error) bool) {
+		var filter ReceiptIn
+		if in != nil {
+			filter = *in
+		}
+
+		if filter.Limit <= 0 {
+			filter.Limit = defaultReceiptPageSize
+		}
+
+		var (
+			brands = make(map[int64]Brand)
+			seen   = make(map[string]bool)
+		)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(ReceiptWithBrand{}, err)
+				return
+			}
+
+			out, err := c.Receipt(ctx, &filter)
+			if err != nil {
+				yield(ReceiptWithBrand{}, errors.Wrapf(err, "get receipts at offset %d", filter.Offset))
+				return
+			}
+
+			for _, brand := range out.Brands {
+				brands[brand.Id] = brand
+			}
+
+			var fresh int
+			for _, receipt := range out.Receipts {
+				if seen[receipt.Key] {
+					continue
+				}
+
+				seen[receipt.Key] = true
+				fresh++
+
+				entry := ReceiptWithBrand{Receipt: receipt}
+				if receipt.BrandId != nil {
+					if brand, ok := brands[*receipt.BrandId]; ok {
+						entry.Brand = &brand
+					}
+				}
+
+				if !yield(entry, nil) {
+					return
+				}
+			}
+
+			if !out.HasMore {
+				return
+			}
+
+			if fresh == 0 {
+				yield(ReceiptWithBrand{}, errors.Errorf("no new receipts at offset %d, but server reports more", filter.Offset))
+				return
+			}
+
+			filter.Offset += len(out.Receipts)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package lkdr
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy configures retries of failed data requests (receipts and fiscal data).
+// Authorization requests are never retried, since they are not idempotent.
+// Delays between attempts are slept using real timers: based.Clock provides no timers,
+// so client Clock is only used to resolve Retry-After dates.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry. It is doubled for each subsequent retry.
+	MinBackoff time.Duration
+
+	// MaxBackoff limits the exponential delay. It does not limit delays requested by Retry-After header.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction of the delay which is randomized, from 0 to 1.
+	Jitter float64
+
+	// RetryableStatuses are HTTP status codes considered transient.
+	RetryableStatuses []int
+
+	// RetryableCodes are error codes considered transient regardless of HTTP status.
+	RetryableCodes []ErrorCode
+}
+
+// DefaultRetryPolicy retries network errors, 429 and 5xx responses.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	MinBackoff:  500 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+	Jitter:      0.5,
+	RetryableStatuses: []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// Retryable reports whether the request which failed with err may succeed if repeated.
+func (p *RetryPolicy) Retryable(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if slices.Contains(p.RetryableStatuses, httpErr.StatusCode) {
+			return true
+		}
+
+		return httpErr.Err != nil && slices.Contains(p.RetryableCodes, httpErr.Err.Code)
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+func (p *RetryPolicy) delay(clock based.Clock, attempt int, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || !p.Retryable(err) {
+		return 0, false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if delay, ok := retryAfter(clock, httpErr.Header); ok {
+			return delay, true
+		}
+	}
+
+	delay := p.MinBackoff << (attempt - 1)
+	if delay <= 0 || p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 {
+		delay -= time.Duration(jitter * rand.Float64() * float64(delay))
+	}
+
+	return delay, true
+}
+
+func retryAfter(clock based.Clock, header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(clock.Now()), 0), true
+	}
+
+	return 0, false
+}
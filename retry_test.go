@@ -0,0 +1,175 @@
+package lkdr_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	clock := lkdrtest.NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	unavailable := &lkdr.HTTPError{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	policy := &lkdr.RetryPolicy{
+		MaxAttempts:       10,
+		MinBackoff:        time.Second,
+		MaxBackoff:        10 * time.Second,
+		RetryableStatuses: []int{http.StatusServiceUnavailable},
+		RetryableCodes:    []lkdr.ErrorCode{"transient"},
+	}
+
+	t.Run("backoff doubles up to max", func(t *testing.T) {
+		var delays []time.Duration
+		for attempt := 1; attempt <= 6; attempt++ {
+			delay, ok := policy.Delay(clock, attempt, unavailable)
+			require.True(t, ok)
+			delays = append(delays, delay)
+		}
+
+		assert.Equal(t, []time.Duration{
+			time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+		}, delays)
+	})
+
+	t.Run("backoff does not overflow", func(t *testing.T) {
+		delay, ok := policy.Delay(clock, 9, unavailable)
+		require.True(t, ok)
+		assert.Equal(t, 10*time.Second, delay)
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		policy := *policy
+		policy.Jitter = 0.5
+		for range 100 {
+			delay, ok := policy.Delay(clock, 3, unavailable)
+			require.True(t, ok)
+			assert.GreaterOrEqual(t, delay, 2*time.Second)
+			assert.LessOrEqual(t, delay, 4*time.Second)
+		}
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		_, ok := policy.Delay(clock, 10, unavailable)
+		assert.False(t, ok)
+	})
+
+	t.Run("retryable errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name      string
+			err       error
+			retryable bool
+		}{
+			{"status", unavailable, true},
+			{"wrapped", errors.Wrap(unavailable, "wrapped"), true},
+			{"code", &lkdr.HTTPError{StatusCode: http.StatusBadRequest, Err: &lkdr.Error{Code: "transient"}}, true},
+			{"network", &url.Error{Op: "Post", URL: "http://localhost", Err: errors.New("connection refused")}, true},
+			{"other status", &lkdr.HTTPError{StatusCode: http.StatusBadRequest}, false},
+			{"other code", &lkdr.HTTPError{StatusCode: http.StatusBadRequest, Err: &lkdr.Error{Code: "permanent"}}, false},
+			{"other error", errors.New("decode json"), false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				assert.Equal(t, tc.retryable, policy.Retryable(tc.err))
+				_, ok := policy.Delay(clock, 1, tc.err)
+				assert.Equal(t, tc.retryable, ok)
+			})
+		}
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		for _, tc := range []struct {
+			name  string
+			value string
+			delay time.Duration
+			ok    bool
+		}{
+			{"seconds", "120", 2 * time.Minute, true},
+			{"negative seconds", "-1", 0, true},
+			{"date", clock.Now().Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+			{"past date", clock.Now().Add(-time.Minute).Format(http.TimeFormat), 0, true},
+			{"invalid", "soon", 0, false},
+			{"missing", "", 0, false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				header := http.Header{}
+				if tc.value != "" {
+					header.Set("Retry-After", tc.value)
+				}
+
+				delay, ok := lkdr.RetryAfter(clock, header)
+				assert.Equal(t, tc.ok, ok)
+				assert.Equal(t, tc.delay, delay)
+			})
+		}
+	})
+
+	t.Run("retry after overrides backoff", func(t *testing.T) {
+		err := &lkdr.HTTPError{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {strconv.Itoa(60)}}}
+		delay, ok := policy.Delay(clock, 1, err)
+		require.True(t, ok)
+		assert.Equal(t, time.Minute, delay, "Retry-After is not limited by MaxBackoff")
+	})
+}
+
+func TestClient_Retry(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	storage := newMemoryStorage()
+	require.NoError(t, storage.UpdateTokens(ctx, phone, server.IssueTokens(phone)))
+	client, err := lkdr.NewClient(lkdr.ClientParams{
+		Phone:        phone,
+		Clock:        lkdrtest.NewClock(time.Now()),
+		DeviceID:     "device",
+		UserAgent:    "test",
+		TokenStorage: storage,
+		BaseURL:      server.URL(),
+		RateLimiter:  lkdr.RateLimiterFunc(func(context.Context, string) error { return nil }),
+		RetryPolicy: &lkdr.RetryPolicy{
+			MaxAttempts:       3,
+			MinBackoff:        time.Millisecond,
+			RetryableStatuses: []int{http.StatusServiceUnavailable},
+		},
+	})
+
+	require.NoError(t, err)
+	unavailable := lkdrtest.Failure{Status: http.StatusServiceUnavailable}
+
+	t.Run("retries data requests", func(t *testing.T) {
+		server.Fail(lkdrtest.ReceiptPath, unavailable, unavailable)
+		_, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+		require.NoError(t, err)
+		assert.Equal(t, 3, server.Requests(lkdrtest.ReceiptPath))
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		server.Fail(lkdrtest.ReceiptPath, unavailable, unavailable, unavailable)
+		_, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+		var httpErr *lkdr.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+		assert.Equal(t, 6, server.Requests(lkdrtest.ReceiptPath))
+	})
+
+	t.Run("does not retry authorization requests", func(t *testing.T) {
+		server.ExpireTokens(phone)
+		server.Fail(lkdrtest.TokenPath, unavailable)
+		_, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+		assert.Error(t, err)
+		assert.Equal(t, 1, server.Requests(lkdrtest.TokenPath))
+
+		server.Fail(lkdrtest.StartPath, unavailable)
+		_, err = client.StartLogin(ctx, "captcha")
+		assert.Error(t, err)
+		assert.Equal(t, 1, server.Requests(lkdrtest.StartPath))
+	})
+}
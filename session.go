@@ -0,0 +1,86 @@
+package lkdr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Session describes the current token state.
+type Session struct {
+	LoggedIn bool
+
+	// TokenExpiresAt is the access token expiry time.
+	TokenExpiresAt time.Time
+
+	// RefreshTokenExpiresAt is the refresh token expiry time, if known.
+	RefreshTokenExpiresAt *time.Time
+
+	// RefreshRequired means that the access token will be refreshed on next request.
+	RefreshRequired bool
+
+	// ReauthRequired means that the next request will require interactive authorization.
+	ReauthRequired bool
+}
+
+// Session returns the current token state without triggering authorization or refresh.
+func (c *Client) Session(ctx context.Context) (*Session, error) {
+	tokens, err := c.tokens.get(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "load token")
+	}
+
+	renewal := c.tokenRenewal(tokens, "")
+	session := &Session{
+		LoggedIn:        tokens != nil,
+		RefreshRequired: renewal == renewRefresh,
+		ReauthRequired:  renewal == renewAuthorize,
+	}
+
+	if tokens != nil {
+		session.TokenExpiresAt = tokens.TokenExpireIn.Time()
+		if tokens.RefreshTokenExpiresIn != nil {
+			expiresAt := tokens.RefreshTokenExpiresIn.Time()
+			session.RefreshTokenExpiresAt = &expiresAt
+		}
+	}
+
+	return session, nil
+}
+
+// Logout removes tokens from TokenStorage along with any active challenge.
+// Tokens are not revoked on the server: the web client is not known to use any revocation endpoint,
+// so the refresh token stays valid on the server side until it expires.
+func (c *Client) Logout(ctx context.Context) error {
+	ctx, cancel := c.tokenMu.Lock(ctx)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, unlock, err := c.lockTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	tokens, err := c.updateTokens(ctx, nil)
+	if err == nil && tokens != nil {
+		// Tokens were renewed concurrently, remove them as well.
+		tokens, err = c.updateTokens(ctx, nil)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "remove tokens")
+	} else if tokens != nil {
+		return errors.New("tokens were renewed concurrently")
+	}
+
+	if err := c.challenges.UpdateChallenge(ctx, c.phone, nil); err != nil {
+		return errors.Wrap(err, "remove challenge")
+	}
+
+	return nil
+}
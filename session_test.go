@@ -0,0 +1,69 @@
+package lkdr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+func TestClient_Session(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	storage := newMemoryStorage()
+	client := newClient(t, server, storage, nil)
+
+	t.Run("logged out", func(t *testing.T) {
+		session, err := client.Session(ctx)
+		require.NoError(t, err)
+		assert.False(t, session.LoggedIn)
+		assert.True(t, session.ReauthRequired)
+	})
+
+	t.Run("logged in", func(t *testing.T) {
+		tokens := server.IssueTokens(phone)
+		require.NoError(t, storage.UpdateTokens(ctx, phone, tokens))
+		client := newClient(t, server, storage, nil)
+
+		session, err := client.Session(ctx)
+		require.NoError(t, err)
+		assert.True(t, session.LoggedIn)
+		assert.False(t, session.RefreshRequired)
+		assert.False(t, session.ReauthRequired)
+		assert.WithinDuration(t, tokens.TokenExpireIn.Time(), session.TokenExpiresAt, time.Second)
+		assert.Zero(t, server.Requests(lkdrtest.TokenPath))
+	})
+}
+
+func TestClient_Logout(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	storage := newMemoryStorage()
+	require.NoError(t, storage.UpdateTokens(ctx, phone, server.IssueTokens(phone)))
+	require.NoError(t, storage.UpdateChallenge(ctx, phone, &lkdr.Challenge{Token: "challenge"}))
+	client := newClient(t, server, storage, nil)
+
+	require.NoError(t, client.Logout(ctx))
+	assert.Zero(t, server.Requests(lkdrtest.TokenPath), "tokens are not refreshed")
+
+	tokens, err := storage.LoadTokens(ctx, phone)
+	require.NoError(t, err)
+	assert.Nil(t, tokens)
+
+	challenge, err := storage.LoadChallenge(ctx, phone)
+	require.NoError(t, err)
+	assert.Nil(t, challenge)
+
+	_, err = client.Receipt(ctx, &lkdr.ReceiptIn{})
+	assert.ErrorIs(t, err, lkdr.ErrReauthRequired)
+	assert.Zero(t, server.Requests(lkdrtest.ReceiptPath))
+}
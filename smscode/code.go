@@ -0,0 +1,97 @@
+// Package smscode provides lkdr.ConfirmationCodeProvider implementations for unattended servers:
+// an HTTP endpoint accepting codes forwarded from the phone and a file watcher.
+// Providers can be combined with Any and plugged into lkdr.CompositeAuthorizer.
+package smscode
+
+import (
+	"context"
+	stderrors "errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+const (
+	defaultTimeout = 5 * time.Minute
+	defaultMaxAge  = 2 * time.Minute
+	pollInterval   = time.Second
+)
+
+var codeRegexp = regexp.MustCompile(`\b\d{4,6}\b`)
+
+// ExtractCode finds confirmation code in SMS text.
+func ExtractCode(text string) (string, bool) {
+	code := codeRegexp.FindString(text)
+	return code, code != ""
+}
+
+// SamePhone compares phone numbers ignoring formatting and country prefix (+7 or 8).
+func SamePhone(a, b string) bool {
+	a, b = normalizePhone(a), normalizePhone(b)
+	return a != "" && a == b
+}
+
+func normalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+
+		return -1
+	}, phone)
+
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+
+	return digits
+}
+
+// Any waits for the first code received by any of the providers.
+func Any(providers ...lkdr.ConfirmationCodeProvider) lkdr.ConfirmationCodeProvider {
+	return lkdr.ConfirmationCodeFunc(func(ctx context.Context, phone string) (string, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type result struct {
+			code string
+			err  error
+		}
+
+		results := make(chan result, len(providers))
+		for _, provider := range providers {
+			go func() {
+				code, err := provider.GetConfirmationCode(ctx, phone)
+				results <- result{code, err}
+			}()
+		}
+
+		var errs []error
+		for range providers {
+			r := <-results
+			if r.err == nil {
+				return r.code, nil
+			}
+
+			errs = append(errs, r.err)
+		}
+
+		if len(errs) == 0 {
+			return "", errors.New("no providers configured")
+		}
+
+		return "", stderrors.Join(errs...)
+	})
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
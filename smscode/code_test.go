@@ -0,0 +1,89 @@
+package smscode_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/smscode"
+)
+
+const phone = "79999999999"
+
+func TestExtractCode(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		code string
+	}{
+		{"1234", "1234"},
+		{"Код подтверждения: 123456. Никому не сообщайте", "123456"},
+		{"Code 12345 for lkdr.nalog.ru", "12345"},
+		{"Code 123", ""},
+		{"Code 1234567", ""},
+		{"", ""},
+	} {
+		t.Run(tc.text, func(t *testing.T) {
+			code, ok := smscode.ExtractCode(tc.text)
+			assert.Equal(t, tc.code, code)
+			assert.Equal(t, tc.code != "", ok)
+		})
+	}
+}
+
+func TestSamePhone(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		same bool
+	}{
+		{"79999999999", "79999999999", true},
+		{"+7 (999) 999-99-99", "89999999999", true},
+		{"9999999999", "79999999999", true},
+		{"79999999999", "79999999998", false},
+		{"", "", false},
+	} {
+		t.Run(tc.a+" "+tc.b, func(t *testing.T) {
+			assert.Equal(t, tc.same, smscode.SamePhone(tc.a, tc.b))
+		})
+	}
+}
+
+func TestAny(t *testing.T) {
+	ctx := context.Background()
+	failing := lkdr.ConfirmationCodeFunc(func(context.Context, string) (string, error) {
+		return "", errors.New("failed")
+	})
+
+	t.Run("returns first code", func(t *testing.T) {
+		blocking := lkdr.ConfirmationCodeFunc(func(ctx context.Context, _ string) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		})
+
+		succeeding := lkdr.ConfirmationCodeFunc(func(context.Context, string) (string, error) {
+			return "1234", nil
+		})
+
+		code, err := smscode.Any(failing, blocking, succeeding).GetConfirmationCode(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "1234", code)
+	})
+
+	t.Run("joins errors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err := smscode.Any(failing, &smscode.Webhook{}).GetConfirmationCode(ctx, phone)
+		assert.ErrorContains(t, err, "failed")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("no providers", func(t *testing.T) {
+		_, err := smscode.Any().GetConfirmationCode(ctx, phone)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,201 @@
+package smscode
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+// FileWatcher polls the file system for confirmation codes.
+//
+// If Path is a directory, the code is read from a file named after the phone (with optional extension),
+// which is removed after reading. File contents may be either the code or the whole SMS text.
+// Only files modified after GetConfirmationCode was called (minus MaxAge) are considered.
+// Otherwise Path is a file with lines "<phone> <code or SMS text>". Only lines appended after GetConfirmationCode
+// was called are considered, so that each code is used once, and the last one matching the phone is used.
+type FileWatcher struct {
+	Path string
+
+	// Clock defaults to based.StandardClock.
+	Clock based.Clock
+
+	// Timeout limits waiting for a code. Defaults to 5 minutes.
+	Timeout time.Duration
+
+	// MaxAge limits the age of files modified before GetConfirmationCode was called in directory mode.
+	// Defaults to 2 minutes.
+	MaxAge time.Duration
+
+	// PollInterval defaults to 1 second.
+	PollInterval time.Duration
+}
+
+func (w FileWatcher) GetConfirmationCode(ctx context.Context, phone string) (string, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	clock := w.Clock
+	if clock == nil {
+		clock = based.StandardClock
+	}
+
+	maxAge := w.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultMaxAge
+	}
+
+	interval := w.PollInterval
+	if interval <= 0 {
+		interval = pollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	offset, err := fileSize(w.Path)
+	if err != nil {
+		return "", err
+	}
+
+	since := clock.Now().Add(-maxAge)
+	for {
+		code, err := w.poll(phone, since, &offset)
+		if err != nil {
+			return "", err
+		}
+
+		if code != "" {
+			return code, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", errors.Wrap(ctx.Err(), "wait for code")
+		}
+	}
+}
+
+func (w FileWatcher) poll(phone string, since time.Time, offset *int64) (string, error) {
+	stat, err := os.Stat(w.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", errors.Wrap(err, "stat")
+	}
+
+	if stat.IsDir() {
+		return w.pollDir(phone, since)
+	}
+
+	if stat.Size() < *offset {
+		// The file was truncated or replaced.
+		*offset = 0
+	}
+
+	if stat.Size() == *offset {
+		return "", nil
+	}
+
+	return w.pollFile(phone, offset)
+}
+
+func (w FileWatcher) pollDir(phone string, since time.Time) (string, error) {
+	entries, err := os.ReadDir(w.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "read dir")
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !SamePhone(strings.TrimSuffix(name, filepath.Ext(name)), phone) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+
+		path := filepath.Join(w.Path, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "read %s", name)
+		}
+
+		code, ok := ExtractCode(string(data))
+		if !ok {
+			// The file may be partially written.
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return "", errors.Wrapf(err, "remove %s", name)
+		}
+
+		return code, nil
+	}
+
+	return "", nil
+}
+
+// pollFile reads complete lines appended after offset and advances it past them.
+func (w FileWatcher) pollFile(phone string, offset *int64) (string, error) {
+	file, err := os.Open(w.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	data, err := io.ReadAll(io.NewSectionReader(file, *offset, math.MaxInt64-*offset))
+	if err != nil {
+		return "", errors.Wrap(err, "read file")
+	}
+
+	// The last line may be partially written.
+	end := bytes.LastIndexByte(data, '\n') + 1
+	*offset += int64(end)
+
+	var code string
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		linePhone, text, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || !SamePhone(linePhone, phone) {
+			continue
+		}
+
+		if lineCode, ok := ExtractCode(text); ok {
+			code = lineCode
+		}
+	}
+
+	return code, nil
+}
+
+// fileSize returns the size of the file at path, or zero if it is a directory or does not exist.
+func fileSize(path string) (int64, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, errors.Wrap(err, "stat")
+	}
+
+	if stat.IsDir() {
+		return 0, nil
+	}
+
+	return stat.Size(), nil
+}
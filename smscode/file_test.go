@@ -0,0 +1,122 @@
+package smscode_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api/smscode"
+)
+
+func appendLine(path, line string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	_, err = file.WriteString(line)
+	return err
+}
+
+func TestFileWatcher_Dir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	watcher := smscode.FileWatcher{Path: dir, Timeout: time.Second, PollInterval: 10 * time.Millisecond}
+
+	t.Run("reads and removes file", func(t *testing.T) {
+		path := filepath.Join(dir, "+7 999 999-99-99.txt")
+		require.NoError(t, os.WriteFile(path, []byte("Код подтверждения: 1234"), 0o600))
+
+		code, err := watcher.GetConfirmationCode(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "1234", code)
+		assert.NoFileExists(t, path)
+	})
+
+	t.Run("waits for file", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = os.WriteFile(filepath.Join(dir, phone), []byte("5678"), 0o600)
+		}()
+
+		code, err := watcher.GetConfirmationCode(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "5678", code)
+	})
+
+	t.Run("ignores old files and other phones", func(t *testing.T) {
+		old := filepath.Join(dir, phone)
+		require.NoError(t, os.WriteFile(old, []byte("1234"), 0o600))
+		require.NoError(t, os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "78888888888"), []byte("5678"), 0o600))
+
+		watcher := watcher
+		watcher.Timeout = 50 * time.Millisecond
+		_, err := watcher.GetConfirmationCode(ctx, phone)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestFileWatcher_File(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "codes.txt")
+	watcher := smscode.FileWatcher{Path: path, Timeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := watcher.GetConfirmationCode(ctx, phone)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("ignores lines written before the call", func(t *testing.T) {
+		require.NoError(t, appendLine(path, phone+" 1234\n"))
+		_, err := watcher.GetConfirmationCode(ctx, phone)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("reads appended lines", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			_ = appendLine(path, "78888888888 1111\n"+phone+" Код 2222\n+79999999999 Код 3333\n")
+		}()
+
+		watcher := watcher
+		watcher.Timeout = time.Second
+		code, err := watcher.GetConfirmationCode(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "3333", code, "last matching line is used")
+	})
+
+	t.Run("waits for complete line", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			_ = appendLine(path, phone+" 44")
+			time.Sleep(50 * time.Millisecond)
+			_ = appendLine(path, "44\n")
+		}()
+
+		watcher := watcher
+		watcher.Timeout = time.Second
+		code, err := watcher.GetConfirmationCode(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "4444", code)
+	})
+
+	t.Run("reads truncated file from start", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			_ = os.WriteFile(path, []byte(phone+" 5555\n"), 0o600)
+		}()
+
+		watcher := watcher
+		watcher.Timeout = time.Second
+		code, err := watcher.GetConfirmationCode(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "5555", code)
+	})
+}
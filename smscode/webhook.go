@@ -0,0 +1,180 @@
+package smscode
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+// MaxWebhookBodySize limits the size of request body accepted by Webhook.
+const MaxWebhookBodySize = 64 << 10
+
+type receivedCode struct {
+	code       string
+	receivedAt time.Time
+}
+
+// Webhook is an http.Handler accepting confirmation codes, e.g. from an SMS forwarding app,
+// and a lkdr.ConfirmationCodeProvider returning them.
+//
+// It accepts POST requests with either JSON body {"phone": "...", "code": "..."} or {"phone": "...", "text": "..."},
+// or form values with the same names. If only SMS text is provided, the code is extracted from it.
+type Webhook struct {
+	// Secret is required in "Authorization: Bearer <secret>" header if set.
+	// Without it any client able to reach the handler may submit a code for any phone,
+	// so it should only be left empty if the handler is not exposed beyond a trusted network.
+	Secret string
+
+	// Clock defaults to based.StandardClock.
+	Clock based.Clock
+
+	// Timeout limits waiting for a code. Defaults to 5 minutes.
+	Timeout time.Duration
+
+	// MaxAge limits the age of codes received before GetConfirmationCode was called. Defaults to 2 minutes.
+	MaxAge time.Duration
+
+	codes   map[string]receivedCode
+	waiters map[chan struct{}]bool
+	mu      sync.Mutex
+}
+
+type webhookIn struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+	Text  string `json:"text"`
+}
+
+func (h *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.Secret != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.Secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxWebhookBodySize)
+
+	var in webhookIn
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeBodyError(w, err, "invalid json")
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			writeBodyError(w, err, "invalid form")
+			return
+		}
+
+		if err := r.ParseMultipartForm(MaxWebhookBodySize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			writeBodyError(w, err, "invalid form")
+			return
+		}
+
+		in.Phone, in.Code, in.Text = r.FormValue("phone"), r.FormValue("code"), r.FormValue("text")
+	}
+
+	code := in.Code
+	if code == "" {
+		code, _ = ExtractCode(in.Text)
+	}
+
+	if normalizePhone(in.Phone) == "" || code == "" {
+		http.Error(w, "phone and code are required", http.StatusBadRequest)
+		return
+	}
+
+	h.put(in.Phone, code)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeBodyError(w http.ResponseWriter, err error, message string) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	http.Error(w, message, http.StatusBadRequest)
+}
+
+func (h *Webhook) GetConfirmationCode(ctx context.Context, phone string) (string, error) {
+	ctx, cancel := withTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultMaxAge
+	}
+
+	since := h.clock().Now().Add(-maxAge)
+	for {
+		wake := make(chan struct{}, 1)
+		if code, ok := h.take(phone, since, wake); ok {
+			return code, nil
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			h.mu.Lock()
+			delete(h.waiters, wake)
+			h.mu.Unlock()
+			return "", errors.Wrap(ctx.Err(), "wait for code")
+		}
+	}
+}
+
+func (h *Webhook) put(phone, code string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.codes == nil {
+		h.codes = make(map[string]receivedCode)
+	}
+
+	h.codes[normalizePhone(phone)] = receivedCode{code: code, receivedAt: h.clock().Now()}
+	for wake := range h.waiters {
+		wake <- struct{}{}
+		delete(h.waiters, wake)
+	}
+}
+
+// take consumes the code for the phone received after since, or registers wake to be notified on new codes.
+func (h *Webhook) take(phone string, since time.Time, wake chan struct{}) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := normalizePhone(phone)
+	if received, ok := h.codes[key]; ok && !received.receivedAt.Before(since) {
+		delete(h.codes, key)
+		return received.code, true
+	}
+
+	if h.waiters == nil {
+		h.waiters = make(map[chan struct{}]bool)
+	}
+
+	h.waiters[wake] = true
+	return "", false
+}
+
+func (h *Webhook) clock() based.Clock {
+	if h.Clock != nil {
+		return h.Clock
+	}
+
+	return based.StandardClock
+}
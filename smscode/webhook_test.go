@@ -0,0 +1,135 @@
+package smscode_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+	"github.com/jfk9w-go/lkdr-api/smscode"
+)
+
+func serve(h *smscode.Webhook, method, contentType, body, secret string) int {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+
+	if secret != "" {
+		r.Header.Set("Authorization", "Bearer "+secret)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestWebhook_ServeHTTP(t *testing.T) {
+	const (
+		jsonType = "application/json"
+		formType = "application/x-www-form-urlencoded"
+	)
+
+	form := func(values ...string) string {
+		form := url.Values{}
+		for i := 0; i < len(values); i += 2 {
+			form.Set(values[i], values[i+1])
+		}
+
+		return form.Encode()
+	}
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		secret      string
+		status      int
+		code        string
+	}{
+		{"json code", http.MethodPost, jsonType, `{"phone":"+79999999999","code":"1234"}`, "secret", http.StatusNoContent, "1234"},
+		{"json text", http.MethodPost, jsonType, `{"phone":"89999999999","text":"Код 5678"}`, "secret", http.StatusNoContent, "5678"},
+		{"form code", http.MethodPost, formType, form("phone", phone, "code", "4321"), "secret", http.StatusNoContent, "4321"},
+		{"form text", http.MethodPost, formType, form("phone", phone, "text", "Код 8765"), "secret", http.StatusNoContent, "8765"},
+		{"method", http.MethodGet, "", "", "secret", http.StatusMethodNotAllowed, ""},
+		{"missing secret", http.MethodPost, jsonType, `{"phone":"79999999999","code":"1234"}`, "", http.StatusUnauthorized, ""},
+		{"invalid secret", http.MethodPost, jsonType, `{"phone":"79999999999","code":"1234"}`, "other", http.StatusUnauthorized, ""},
+		{"invalid json", http.MethodPost, jsonType, `{`, "secret", http.StatusBadRequest, ""},
+		{"missing code", http.MethodPost, jsonType, `{"phone":"79999999999","text":"no code"}`, "secret", http.StatusBadRequest, ""},
+		{"missing phone", http.MethodPost, formType, form("code", "1234"), "secret", http.StatusBadRequest, ""},
+		{"large json", http.MethodPost, jsonType, `{"phone":"79999999999","text":"` + strings.Repeat("x", smscode.MaxWebhookBodySize) + `"}`,
+			"secret", http.StatusRequestEntityTooLarge, ""},
+		{"large form", http.MethodPost, formType, form("phone", phone, "text", strings.Repeat("x", smscode.MaxWebhookBodySize)),
+			"secret", http.StatusRequestEntityTooLarge, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &smscode.Webhook{Secret: "secret", Timeout: 10 * time.Millisecond}
+			assert.Equal(t, tc.status, serve(h, tc.method, tc.contentType, tc.body, tc.secret))
+
+			code, err := h.GetConfirmationCode(context.Background(), phone)
+			if tc.code != "" {
+				require.NoError(t, err)
+				assert.Equal(t, tc.code, code)
+			} else {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestWebhook_GetConfirmationCode(t *testing.T) {
+	ctx := context.Background()
+	post := func(h *smscode.Webhook, code string) {
+		require.Equal(t, http.StatusNoContent, serve(h, http.MethodPost, "application/json", `{"phone":"79999999999","code":"`+code+`"}`, ""))
+	}
+
+	t.Run("waits for code", func(t *testing.T) {
+		h := new(smscode.Webhook)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			serve(h, http.MethodPost, "application/json", `{"phone":"79999999999","code":"1234"}`, "")
+		}()
+
+		code, err := h.GetConfirmationCode(ctx, "+7 999 999 99 99")
+		require.NoError(t, err)
+		assert.Equal(t, "1234", code)
+	})
+
+	t.Run("code is used once", func(t *testing.T) {
+		h := &smscode.Webhook{Timeout: 10 * time.Millisecond}
+		post(h, "1234")
+
+		code, err := h.GetConfirmationCode(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "1234", code)
+
+		_, err = h.GetConfirmationCode(ctx, phone)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("old code is ignored", func(t *testing.T) {
+		clock := lkdrtest.NewClock(time.Now())
+		h := &smscode.Webhook{Clock: clock, MaxAge: time.Minute, Timeout: 10 * time.Millisecond}
+		post(h, "1234")
+
+		clock.Advance(2 * time.Minute)
+		_, err := h.GetConfirmationCode(ctx, phone)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("other phone", func(t *testing.T) {
+		h := &smscode.Webhook{Timeout: 10 * time.Millisecond}
+		post(h, "1234")
+
+		_, err := h.GetConfirmationCode(ctx, "78888888888")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,345 @@
+// Package sqlstorage provides lkdr.TokenStorage, lkdr.ChallengeStorage and lkdr.TokenLocker backed by database/sql.
+//
+// Tokens are versioned: UpdateTokens succeeds only if the row was not changed since it was last loaded
+// by this Storage, and returns lkdr.ErrTokensConflict otherwise. This way several replicas sharing
+// one phone do not overwrite each other's refreshed tokens.
+package sqlstorage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+const (
+	defaultLockLease = 10 * time.Minute
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// Dialect defines SQL placeholder syntax.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+type StorageParams struct {
+	DB      *sql.DB `validate:"required"`
+	Dialect Dialect `validate:"oneof=postgres sqlite"`
+
+	// Clock is used for token lock leases in SQLite. Defaults to based.StandardClock.
+	Clock based.Clock
+
+	// LockLease is the time after which a token lock held by a crashed process may be taken over in SQLite.
+	LockLease time.Duration
+}
+
+// Storage is a database/sql based storage. Call Migrate before use.
+type Storage struct {
+	db        *sql.DB
+	dialect   Dialect
+	clock     based.Clock
+	lockLease time.Duration
+	versions  map[string]int64
+	mu        sync.Mutex
+}
+
+func New(params StorageParams) (*Storage, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	if params.Clock == nil {
+		params.Clock = based.StandardClock
+	}
+
+	if params.LockLease <= 0 {
+		params.LockLease = defaultLockLease
+	}
+
+	return &Storage{
+		db:        params.DB,
+		dialect:   params.Dialect,
+		clock:     params.Clock,
+		lockLease: params.LockLease,
+		versions:  make(map[string]int64),
+	}, nil
+}
+
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS lkdr_tokens (
+		phone TEXT PRIMARY KEY,
+		tokens TEXT NOT NULL,
+		version BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS lkdr_challenges (
+		phone TEXT PRIMARY KEY,
+		challenge TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS lkdr_token_locks (
+		phone TEXT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`,
+}
+
+// Migrate creates or updates the schema. It is safe to call concurrently from several replicas.
+func (s *Storage) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS lkdr_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return errors.Wrap(err, "create migrations table")
+	}
+
+	for i, migration := range migrations {
+		if err := s.migrate(ctx, i+1, migration); err != nil {
+			return errors.Wrapf(err, "apply migration %d", i+1)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) migrate(ctx context.Context, version int, migration string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO lkdr_migrations (version) VALUES (?) ON CONFLICT (version) DO NOTHING`), version)
+	if err != nil {
+		return errors.Wrap(err, "insert version")
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.Wrap(err, "get affected rows")
+	} else if affected == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) LoadTokens(ctx context.Context, phone string) (*lkdr.Tokens, error) {
+	var (
+		data    string
+		version int64
+	)
+
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT tokens, version FROM lkdr_tokens WHERE phone = ?`), phone).Scan(&data, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.setVersion(phone, 0)
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "select tokens")
+	}
+
+	var tokens lkdr.Tokens
+	if err := json.Unmarshal([]byte(data), &tokens); err != nil {
+		return nil, errors.Wrap(err, "decode tokens")
+	}
+
+	s.setVersion(phone, version)
+	return &tokens, nil
+}
+
+func (s *Storage) UpdateTokens(ctx context.Context, phone string, tokens *lkdr.Tokens) error {
+	version := s.getVersion(phone)
+
+	var (
+		result sql.Result
+		err    error
+	)
+
+	switch {
+	case tokens == nil:
+		result, err = s.db.ExecContext(ctx, s.rebind(`DELETE FROM lkdr_tokens WHERE phone = ? AND version = ?`), phone, version)
+		version = 0
+	case version == 0:
+		var data []byte
+		if data, err = json.Marshal(tokens); err != nil {
+			return errors.Wrap(err, "encode tokens")
+		}
+
+		result, err = s.db.ExecContext(ctx, s.rebind(`INSERT INTO lkdr_tokens (phone, tokens, version) VALUES (?, ?, 1) ON CONFLICT (phone) DO NOTHING`), phone, string(data))
+		version = 1
+	default:
+		var data []byte
+		if data, err = json.Marshal(tokens); err != nil {
+			return errors.Wrap(err, "encode tokens")
+		}
+
+		result, err = s.db.ExecContext(ctx, s.rebind(`UPDATE lkdr_tokens SET tokens = ?, version = version + 1 WHERE phone = ? AND version = ?`), string(data), phone, version)
+		version++
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "update tokens")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get affected rows")
+	}
+
+	if affected == 0 {
+		if tokens == nil {
+			if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT version FROM lkdr_tokens WHERE phone = ?`), phone).Scan(&version); errors.Is(err, sql.ErrNoRows) {
+				s.setVersion(phone, 0)
+				return nil
+			} else if err != nil {
+				return errors.Wrap(err, "select version")
+			}
+		}
+
+		return lkdr.ErrTokensConflict
+	}
+
+	s.setVersion(phone, version)
+	return nil
+}
+
+func (s *Storage) LoadChallenge(ctx context.Context, phone string) (*lkdr.Challenge, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT challenge FROM lkdr_challenges WHERE phone = ?`), phone).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "select challenge")
+	}
+
+	var challenge lkdr.Challenge
+	if err := json.Unmarshal([]byte(data), &challenge); err != nil {
+		return nil, errors.Wrap(err, "decode challenge")
+	}
+
+	return &challenge, nil
+}
+
+func (s *Storage) UpdateChallenge(ctx context.Context, phone string, challenge *lkdr.Challenge) error {
+	if challenge == nil {
+		_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM lkdr_challenges WHERE phone = ?`), phone)
+		return errors.Wrap(err, "delete challenge")
+	}
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return errors.Wrap(err, "encode challenge")
+	}
+
+	_, err = s.db.ExecContext(ctx, s.rebind(`INSERT INTO lkdr_challenges (phone, challenge) VALUES (?, ?) `+
+		`ON CONFLICT (phone) DO UPDATE SET challenge = excluded.challenge`), phone, string(data))
+	return errors.Wrap(err, "upsert challenge")
+}
+
+// LockTokens implements lkdr.TokenLocker.
+// Postgres session-level advisory lock is used for Postgres, and a lease in lkdr_token_locks table for SQLite.
+func (s *Storage) LockTokens(ctx context.Context, phone string) (func(), error) {
+	if s.dialect == Postgres {
+		return s.advisoryLock(ctx, phone)
+	}
+
+	return s.leaseLock(ctx, phone)
+}
+
+func (s *Storage) advisoryLock(ctx context.Context, phone string) (func(), error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get connection")
+	}
+
+	key := "lkdr_tokens:" + phone
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, key); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "acquire advisory lock")
+	}
+
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, key)
+		_ = conn.Close()
+	}, nil
+}
+
+func (s *Storage) leaseLock(ctx context.Context, phone string) (func(), error) {
+	var owner [16]byte
+	if _, err := rand.Read(owner[:]); err != nil {
+		return nil, errors.Wrap(err, "generate lock owner")
+	}
+
+	ownerID := hex.EncodeToString(owner[:])
+	for {
+		now := s.clock.Now()
+		result, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO lkdr_token_locks (phone, owner, expires_at) VALUES (?, ?, ?) `+
+			`ON CONFLICT (phone) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at `+
+			`WHERE lkdr_token_locks.expires_at < ?`),
+			phone, ownerID, now.Add(s.lockLease).UnixMilli(), now.UnixMilli())
+		if err != nil {
+			return nil, errors.Wrap(err, "acquire lease")
+		}
+
+		if affected, err := result.RowsAffected(); err != nil {
+			return nil, errors.Wrap(err, "get affected rows")
+		} else if affected > 0 {
+			return func() {
+				_, _ = s.db.ExecContext(context.Background(), s.rebind(`DELETE FROM lkdr_token_locks WHERE phone = ? AND owner = ?`), phone, ownerID)
+			}, nil
+		}
+
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Storage) getVersion(phone string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versions[phone]
+}
+
+func (s *Storage) setVersion(phone string, version int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[phone] = version
+}
+
+// rebind replaces ? placeholders with dialect-specific ones.
+func (s *Storage) rebind(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
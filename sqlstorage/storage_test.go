@@ -0,0 +1,237 @@
+package sqlstorage_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+	"github.com/jfk9w-go/lkdr-api/sqlstorage"
+)
+
+const phone = "79999999999"
+
+func openDB(t *testing.T) *sql.DB {
+	path := filepath.Join(t.TempDir(), "lkdr.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newStorage(t *testing.T, db *sql.DB, clock based.Clock) *sqlstorage.Storage {
+	storage, err := sqlstorage.New(sqlstorage.StorageParams{
+		DB:        db,
+		Dialect:   sqlstorage.SQLite,
+		Clock:     clock,
+		LockLease: time.Minute,
+	})
+
+	require.NoError(t, err)
+	require.NoError(t, storage.Migrate(context.Background()))
+	return storage
+}
+
+// unlocked hides TokenLocker implementation of the storage.
+type unlocked struct {
+	lkdr.TokenStorage
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("migrate is idempotent", func(t *testing.T) {
+		db := openDB(t)
+		storage := newStorage(t, db, nil)
+		require.NoError(t, storage.Migrate(ctx))
+
+		var migrations int
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM lkdr_migrations`).Scan(&migrations))
+		assert.Equal(t, 3, migrations)
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		db := openDB(t)
+		first, second := newStorage(t, db, nil), newStorage(t, db, nil)
+		require.NoError(t, first.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: "initial"}))
+		for _, storage := range []*sqlstorage.Storage{first, second} {
+			_, err := storage.LoadTokens(ctx, phone)
+			require.NoError(t, err)
+		}
+
+		var (
+			errs [2]error
+			wg   sync.WaitGroup
+		)
+
+		for i, storage := range []*sqlstorage.Storage{first, second} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = storage.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: string(rune('a' + i))})
+			}()
+		}
+
+		wg.Wait()
+
+		winner := 0
+		if errs[0] != nil {
+			winner = 1
+		}
+
+		require.NoError(t, errs[winner])
+		assert.ErrorIs(t, errs[1-winner], lkdr.ErrTokensConflict)
+
+		tokens, err := first.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, string(rune('a'+winner)), tokens.Token)
+	})
+
+	t.Run("update after conflict requires reload", func(t *testing.T) {
+		db := openDB(t)
+		first, second := newStorage(t, db, nil), newStorage(t, db, nil)
+		require.NoError(t, first.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: "first"}))
+		assert.ErrorIs(t, second.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: "second"}), lkdr.ErrTokensConflict)
+
+		tokens, err := second.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "first", tokens.Token)
+		require.NoError(t, second.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: "second"}))
+		assert.ErrorIs(t, first.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: "first"}), lkdr.ErrTokensConflict)
+	})
+
+	t.Run("delete conflict", func(t *testing.T) {
+		db := openDB(t)
+		first, second := newStorage(t, db, nil), newStorage(t, db, nil)
+		require.NoError(t, first.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: "initial"}))
+		_, err := second.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		require.NoError(t, first.UpdateTokens(ctx, phone, &lkdr.Tokens{Token: "renewed"}))
+
+		assert.ErrorIs(t, second.UpdateTokens(ctx, phone, nil), lkdr.ErrTokensConflict)
+		tokens, err := first.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Equal(t, "renewed", tokens.Token)
+
+		require.NoError(t, first.UpdateTokens(ctx, phone, nil))
+		require.NoError(t, second.UpdateTokens(ctx, phone, nil), "removing removed tokens is not a conflict")
+		tokens, err = second.LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		assert.Nil(t, tokens)
+	})
+
+	t.Run("lease lock", func(t *testing.T) {
+		db := openDB(t)
+		clock := lkdrtest.NewClock(time.Now())
+		first, second := newStorage(t, db, clock), newStorage(t, db, clock)
+
+		unlock, err := first.LockTokens(ctx, phone)
+		require.NoError(t, err)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		_, err = second.LockTokens(timeoutCtx, phone)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		unlock()
+		unlock, err = second.LockTokens(ctx, phone)
+		require.NoError(t, err)
+
+		clock.Advance(2 * time.Minute)
+		takeover, err := first.LockTokens(ctx, phone)
+		require.NoError(t, err, "expired lease is taken over")
+
+		unlock()
+		timeoutCtx, cancel = context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		_, err = second.LockTokens(timeoutCtx, phone)
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "stale owner must not release the lease")
+		takeover()
+	})
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	newClient := func(t *testing.T, storage lkdr.TokenStorage) *lkdr.Client {
+		client, err := lkdr.NewClient(lkdr.ClientParams{
+			Phone:        phone,
+			Clock:        based.StandardClock,
+			DeviceID:     "device",
+			UserAgent:    "test",
+			TokenStorage: storage,
+			BaseURL:      server.URL(),
+			Authorizer:   &lkdrtest.Authorizer{Server: server},
+		})
+
+		require.NoError(t, err)
+		return client
+	}
+
+	t.Run("adopts tokens refreshed by another replica on conflict", func(t *testing.T) {
+		db := openDB(t)
+		initial := server.IssueTokens(phone)
+		require.NoError(t, newStorage(t, db, nil).UpdateTokens(ctx, phone, initial))
+
+		first := newClient(t, unlocked{newStorage(t, db, nil)})
+		second := newClient(t, unlocked{newStorage(t, db, nil)})
+		for _, client := range []*lkdr.Client{first, second} {
+			_, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+			require.NoError(t, err)
+		}
+
+		server.ExpireTokens(phone)
+		refreshes := server.Requests(lkdrtest.TokenPath)
+		_, err := first.Receipt(ctx, &lkdr.ReceiptIn{})
+		require.NoError(t, err)
+
+		// The second replica fails to refresh with the rotated refresh token, then fails to remove
+		// the tokens due to the conflict and adopts the ones refreshed by the first replica.
+		_, err = second.Receipt(ctx, &lkdr.ReceiptIn{})
+		require.NoError(t, err)
+		assert.Equal(t, refreshes+2, server.Requests(lkdrtest.TokenPath))
+		assert.Zero(t, server.Requests(lkdrtest.StartPath))
+
+		tokens, err := newStorage(t, db, nil).LoadTokens(ctx, phone)
+		require.NoError(t, err)
+		require.NotNil(t, tokens)
+		assert.NotEqual(t, initial.RefreshToken, tokens.RefreshToken)
+	})
+
+	t.Run("renews tokens once under lock", func(t *testing.T) {
+		db := openDB(t)
+		require.NoError(t, newStorage(t, db, nil).UpdateTokens(ctx, phone, server.IssueTokens(phone)))
+
+		var clients []*lkdr.Client
+		for range 5 {
+			clients = append(clients, newClient(t, newStorage(t, db, nil)))
+		}
+
+		server.ExpireTokens(phone)
+		refreshes := server.Requests(lkdrtest.TokenPath)
+
+		var wg sync.WaitGroup
+		for _, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := client.Receipt(ctx, &lkdr.ReceiptIn{})
+				assert.NoError(t, err)
+			}()
+		}
+
+		wg.Wait()
+		assert.Equal(t, refreshes+1, server.Requests(lkdrtest.TokenPath))
+	})
+}
@@ -0,0 +1,493 @@
+// Package store archives receipts, brands and fiscal data in SQLite, so that they can be queried
+// without requesting the API again.
+//
+// The package does not import an SQLite driver: open *sql.DB with one of your choice
+// (e.g. modernc.org/sqlite or github.com/mattn/go-sqlite3) and call Migrate before use.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w-go/lkdr-api"
+)
+
+// Store is an SQLite receipt archive. It implements lkdr.SyncSink.
+type Store struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS lkdr_brands (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL,
+		image TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS lkdr_receipts (
+		key TEXT PRIMARY KEY,
+		brand_id INTEGER,
+		buyer TEXT NOT NULL,
+		buyer_type TEXT NOT NULL,
+		created_date INTEGER NOT NULL,
+		fiscal_document_number TEXT NOT NULL,
+		fiscal_drive_number TEXT NOT NULL,
+		kkt_owner TEXT NOT NULL,
+		kkt_owner_inn TEXT NOT NULL,
+		receive_date INTEGER NOT NULL,
+		total_sum TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS lkdr_receipts_created_date ON lkdr_receipts (created_date)`,
+	`CREATE INDEX IF NOT EXISTS lkdr_receipts_kkt_owner_inn ON lkdr_receipts (kkt_owner_inn)`,
+	`CREATE INDEX IF NOT EXISTS lkdr_receipts_brand_id ON lkdr_receipts (brand_id)`,
+	`CREATE TABLE IF NOT EXISTS lkdr_fiscal_data (
+		key TEXT PRIMARY KEY,
+		buyer_address TEXT NOT NULL,
+		cash_total_sum REAL NOT NULL,
+		credit_sum REAL NOT NULL,
+		date_time INTEGER NOT NULL,
+		ecash_total_sum REAL NOT NULL,
+		fiscal_document_format_ver TEXT NOT NULL,
+		fiscal_document_number INTEGER NOT NULL,
+		fiscal_drive_number TEXT NOT NULL,
+		fiscal_sign TEXT NOT NULL,
+		internet_sign INTEGER,
+		kkt_reg_id TEXT NOT NULL,
+		machine_number TEXT,
+		nds10 REAL,
+		nds18 REAL,
+		operation_type INTEGER NOT NULL,
+		operator TEXT,
+		prepaid_sum REAL NOT NULL,
+		provision_sum REAL NOT NULL,
+		request_number INTEGER NOT NULL,
+		retail_place TEXT,
+		retail_place_address TEXT,
+		shift_number INTEGER NOT NULL,
+		taxation_type INTEGER NOT NULL,
+		total_sum REAL NOT NULL,
+		user TEXT,
+		user_inn TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS lkdr_fiscal_items (
+		key TEXT NOT NULL REFERENCES lkdr_fiscal_data (key) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		nds INTEGER NOT NULL,
+		payment_type INTEGER NOT NULL,
+		price REAL NOT NULL,
+		product_type INTEGER NOT NULL,
+		provider_name TEXT,
+		provider_phone TEXT,
+		provider_inn TEXT,
+		quantity REAL NOT NULL,
+		sum REAL NOT NULL,
+		PRIMARY KEY (key, position)
+	)`,
+	`CREATE INDEX IF NOT EXISTS lkdr_fiscal_items_name ON lkdr_fiscal_items (name)`,
+	`ALTER TABLE lkdr_receipts ADD COLUMN raw TEXT`,
+	`ALTER TABLE lkdr_fiscal_data ADD COLUMN raw TEXT`,
+}
+
+// Migrate creates or updates the schema.
+func (s *Store) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS lkdr_store_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return errors.Wrap(err, "create migrations table")
+	}
+
+	for i, migration := range migrations {
+		if err := s.migrate(ctx, i+1, migration); err != nil {
+			return errors.Wrapf(err, "apply migration %d", i+1)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) migrate(ctx context.Context, version int, migration string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO lkdr_store_migrations (version) VALUES (?) ON CONFLICT (version) DO NOTHING`, version)
+	if err != nil {
+		return errors.Wrap(err, "insert version")
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.Wrap(err, "get affected rows")
+	} else if affected == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PutReceiptOut upserts brands and receipts from the response.
+func (s *Store) PutReceiptOut(ctx context.Context, out *lkdr.ReceiptOut) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, brand := range out.Brands {
+			if err := putBrand(ctx, tx, &brand); err != nil {
+				return errors.Wrapf(err, "put brand %d", brand.Id)
+			}
+		}
+
+		for _, receipt := range out.Receipts {
+			if err := putReceipt(ctx, tx, &receipt); err != nil {
+				return errors.Wrapf(err, "put receipt %s", receipt.Key)
+			}
+		}
+
+		return nil
+	})
+}
+
+// PutBrands upserts brands.
+func (s *Store) PutBrands(ctx context.Context, brands ...lkdr.Brand) error {
+	return s.PutReceiptOut(ctx, &lkdr.ReceiptOut{Brands: brands})
+}
+
+// PutReceipts upserts receipts.
+func (s *Store) PutReceipts(ctx context.Context, receipts ...lkdr.Receipt) error {
+	return s.PutReceiptOut(ctx, &lkdr.ReceiptOut{Receipts: receipts})
+}
+
+// PutFiscalData upserts fiscal data for the receipt key, replacing its items.
+func (s *Store) PutFiscalData(ctx context.Context, key string, data *lkdr.FiscalDataOut) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return putFiscalData(ctx, tx, key, data)
+	})
+}
+
+// Consume implements lkdr.SyncSink.
+func (s *Store) Consume(ctx context.Context, phone string, receipt *lkdr.SyncedReceipt) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if receipt.Brand != nil {
+			if err := putBrand(ctx, tx, receipt.Brand); err != nil {
+				return errors.Wrap(err, "put brand")
+			}
+		}
+
+		if err := putReceipt(ctx, tx, &receipt.Receipt); err != nil {
+			return errors.Wrap(err, "put receipt")
+		}
+
+		if receipt.FiscalData != nil {
+			if err := putFiscalData(ctx, tx, receipt.Key, receipt.FiscalData); err != nil {
+				return errors.Wrap(err, "put fiscal data")
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+func putBrand(ctx context.Context, tx *sql.Tx, brand *lkdr.Brand) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO lkdr_brands (id, name, description, image) VALUES (?, ?, ?, ?) `+
+		`ON CONFLICT (id) DO UPDATE SET name = excluded.name, description = excluded.description, image = excluded.image`,
+		brand.Id, brand.Name, brand.Description, brand.Image)
+	return err
+}
+
+const receiptColumns = `key, brand_id, buyer, buyer_type, created_date, fiscal_document_number, fiscal_drive_number, ` +
+	`kkt_owner, kkt_owner_inn, receive_date, total_sum, raw`
+
+func putReceipt(ctx context.Context, tx *sql.Tx, r *lkdr.Receipt) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO lkdr_receipts (`+receiptColumns+`) VALUES (`+placeholders(receiptColumns)+`) `+
+		`ON CONFLICT (key) DO UPDATE SET `+excludedSet(receiptColumns),
+		r.Key, r.BrandId, r.Buyer, r.BuyerType, r.CreatedDate.Time().Unix(), r.FiscalDocumentNumber, r.FiscalDriveNumber,
+		r.KktOwner, r.KktOwnerInn, r.ReceiveDate.Time().Unix(), r.TotalSum, rawValue(r.Raw))
+	return err
+}
+
+const fiscalDataColumns = `key, buyer_address, cash_total_sum, credit_sum, date_time, ecash_total_sum, fiscal_document_format_ver, ` +
+	`fiscal_document_number, fiscal_drive_number, fiscal_sign, internet_sign, kkt_reg_id, machine_number, nds10, nds18, ` +
+	`operation_type, operator, prepaid_sum, provision_sum, request_number, retail_place, retail_place_address, ` +
+	`shift_number, taxation_type, total_sum, user, user_inn, raw`
+
+const fiscalItemColumns = `key, position, name, nds, payment_type, price, product_type, provider_name, provider_phone, ` +
+	`provider_inn, quantity, sum`
+
+func putFiscalData(ctx context.Context, tx *sql.Tx, key string, d *lkdr.FiscalDataOut) error {
+	if _, err := tx.ExecContext(ctx, `INSERT INTO lkdr_fiscal_data (`+fiscalDataColumns+`) VALUES (`+placeholders(fiscalDataColumns)+`) `+
+		`ON CONFLICT (key) DO UPDATE SET `+excludedSet(fiscalDataColumns),
+		key, d.BuyerAddress, d.CashTotalSum, d.CreditSum, d.DateTime.Time().Unix(), d.EcashTotalSum, d.FiscalDocumentFormatVer,
+		d.FiscalDocumentNumber, d.FiscalDriveNumber, d.FiscalSign, d.InternetSign, d.KktRegId, d.MachineNumber, d.Nds10, d.Nds18,
+		d.OperationType, d.Operator, d.PrepaidSum, d.ProvisionSum, d.RequestNumber, d.RetailPlace, d.RetailPlaceAddress,
+		d.ShiftNumber, d.TaxationType, d.TotalSum, d.User, d.UserInn, rawValue(d.Raw)); err != nil {
+		return errors.Wrap(err, "upsert fiscal data")
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM lkdr_fiscal_items WHERE key = ?`, key); err != nil {
+		return errors.Wrap(err, "delete items")
+	}
+
+	for i, item := range d.Items {
+		var providerName, providerPhone *string
+		if item.ProviderData != nil {
+			providerName = &item.ProviderData.ProviderName
+			phones, err := json.Marshal(item.ProviderData.ProviderPhone)
+			if err != nil {
+				return errors.Wrap(err, "encode provider phone")
+			}
+
+			value := string(phones)
+			providerPhone = &value
+		}
+
+		if _, err := tx.ExecContext(ctx, `INSERT INTO lkdr_fiscal_items (`+fiscalItemColumns+`) VALUES (`+placeholders(fiscalItemColumns)+`)`,
+			key, i, item.Name, item.Nds, item.PaymentType, item.Price, item.ProductType, providerName, providerPhone,
+			item.ProviderInn, item.Quantity, item.Sum); err != nil {
+			return errors.Wrapf(err, "insert item %d", i)
+		}
+	}
+
+	return nil
+}
+
+// Query is a receipt filter. Zero fields are ignored.
+type Query struct {
+	// From and To limit receipt CreatedDate, inclusive and exclusive respectively.
+	From, To time.Time
+
+	// Inn is the seller INN (Receipt.KktOwnerInn).
+	Inn string
+
+	BrandID *int64
+
+	// ItemName matches receipts with fiscal data items containing the substring.
+	ItemName string
+
+	Limit  int
+	Offset int
+}
+
+// Receipts returns receipts matching the query ordered by CreatedDate from newest to oldest.
+func (s *Store) Receipts(ctx context.Context, q Query) ([]lkdr.ReceiptWithBrand, error) {
+	var (
+		where []string
+		args  []any
+	)
+
+	if !q.From.IsZero() {
+		where = append(where, `r.created_date >= ?`)
+		args = append(args, q.From.Unix())
+	}
+
+	if !q.To.IsZero() {
+		where = append(where, `r.created_date < ?`)
+		args = append(args, q.To.Unix())
+	}
+
+	if q.Inn != "" {
+		where = append(where, `r.kkt_owner_inn = ?`)
+		args = append(args, q.Inn)
+	}
+
+	if q.BrandID != nil {
+		where = append(where, `r.brand_id = ?`)
+		args = append(args, *q.BrandID)
+	}
+
+	if q.ItemName != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM lkdr_fiscal_items i WHERE i.key = r.key AND i.name LIKE ? ESCAPE '\')`)
+		args = append(args, "%"+escapeLike(q.ItemName)+"%")
+	}
+
+	query := `SELECT ` + prefixed("r.", receiptColumns) + `, b.id, b.name, b.description, b.image ` +
+		`FROM lkdr_receipts r LEFT JOIN lkdr_brands b ON b.id = r.brand_id`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	query += ` ORDER BY r.created_date DESC, r.key`
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, q.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "select receipts")
+	}
+
+	defer rows.Close()
+
+	var receipts []lkdr.ReceiptWithBrand
+	for rows.Next() {
+		var (
+			entry                    lkdr.ReceiptWithBrand
+			createdDate, receiveDate int64
+			brandID                  *int64
+			brandName, brandDesc     *string
+			brandImage               *string
+			raw                      *string
+		)
+
+		r := &entry.Receipt
+		if err := rows.Scan(&r.Key, &r.BrandId, &r.Buyer, &r.BuyerType, &createdDate, &r.FiscalDocumentNumber, &r.FiscalDriveNumber,
+			&r.KktOwner, &r.KktOwnerInn, &receiveDate, &r.TotalSum, &raw, &brandID, &brandName, &brandDesc, &brandImage); err != nil {
+			return nil, errors.Wrap(err, "scan receipt")
+		}
+
+		r.CreatedDate = lkdr.DateTime(time.Unix(createdDate, 0))
+		r.ReceiveDate = lkdr.DateTime(time.Unix(receiveDate, 0))
+		r.Raw = rawMessage(raw)
+		if brandID != nil {
+			entry.Brand = &lkdr.Brand{Id: *brandID, Name: deref(brandName), Description: deref(brandDesc), Image: brandImage}
+		}
+
+		receipts = append(receipts, entry)
+	}
+
+	return receipts, errors.Wrap(rows.Err(), "iterate receipts")
+}
+
+// FiscalData returns fiscal data for the receipt key, or nil if it is not stored.
+func (s *Store) FiscalData(ctx context.Context, key string) (*lkdr.FiscalDataOut, error) {
+	var (
+		d        lkdr.FiscalDataOut
+		dateTime int64
+		raw      *string
+	)
+
+	err := s.db.QueryRowContext(ctx, `SELECT `+fiscalDataColumns+` FROM lkdr_fiscal_data WHERE key = ?`, key).Scan(
+		&key, &d.BuyerAddress, &d.CashTotalSum, &d.CreditSum, &dateTime, &d.EcashTotalSum, &d.FiscalDocumentFormatVer,
+		&d.FiscalDocumentNumber, &d.FiscalDriveNumber, &d.FiscalSign, &d.InternetSign, &d.KktRegId, &d.MachineNumber, &d.Nds10, &d.Nds18,
+		&d.OperationType, &d.Operator, &d.PrepaidSum, &d.ProvisionSum, &d.RequestNumber, &d.RetailPlace, &d.RetailPlaceAddress,
+		&d.ShiftNumber, &d.TaxationType, &d.TotalSum, &d.User, &d.UserInn, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "select fiscal data")
+	}
+
+	d.DateTime = lkdr.DateTime(time.Unix(dateTime, 0))
+	d.Raw = rawMessage(raw)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+fiscalItemColumns+` FROM lkdr_fiscal_items WHERE key = ? ORDER BY position`, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "select items")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			item                        lkdr.FiscalDataItem
+			position                    int
+			providerName, providerPhone *string
+		)
+
+		if err := rows.Scan(&key, &position, &item.Name, &item.Nds, &item.PaymentType, &item.Price, &item.ProductType,
+			&providerName, &providerPhone, &item.ProviderInn, &item.Quantity, &item.Sum); err != nil {
+			return nil, errors.Wrap(err, "scan item")
+		}
+
+		if providerName != nil || providerPhone != nil {
+			item.ProviderData = &lkdr.ProviderData{ProviderName: deref(providerName)}
+			if providerPhone != nil {
+				if err := json.Unmarshal([]byte(*providerPhone), &item.ProviderData.ProviderPhone); err != nil {
+					return nil, errors.Wrap(err, "decode provider phone")
+				}
+			}
+		}
+
+		d.Items = append(d.Items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate items")
+	}
+
+	return &d, nil
+}
+
+func placeholders(columns string) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", strings.Count(columns, ",")+1), ", ")
+}
+
+// excludedSet builds SET clause for upsert updating all columns except the first one (primary key).
+// Stored raw JSON is kept if the new value has none (e.g. it was built by hand, not decoded from a response).
+func excludedSet(columns string) string {
+	names := strings.Split(columns, ", ")[1:]
+	for i, name := range names {
+		if name == "raw" {
+			names[i] = "raw = COALESCE(excluded.raw, raw)"
+			continue
+		}
+
+		names[i] = name + " = excluded." + name
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func prefixed(prefix, columns string) string {
+	return prefix + strings.ReplaceAll(columns, ", ", ", "+prefix)
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func rawValue(raw json.RawMessage) *string {
+	if raw == nil {
+		return nil
+	}
+
+	value := string(raw)
+	return &value
+}
+
+func rawMessage(value *string) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+
+	return json.RawMessage(*value)
+}
+
+func deref(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
@@ -0,0 +1,172 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/store"
+)
+
+func newStore(t *testing.T) (*store.Store, *sql.DB) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "store.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	s := store.New(db)
+	require.NoError(t, s.Migrate(context.Background()))
+	return s, db
+}
+
+func keys(receipts []lkdr.ReceiptWithBrand) []string {
+	keys := make([]string, len(receipts))
+	for i, receipt := range receipts {
+		keys[i] = receipt.Key
+	}
+
+	return keys
+}
+
+func TestStore_Migrate(t *testing.T) {
+	ctx := context.Background()
+	s, db := newStore(t)
+	require.NoError(t, s.Migrate(ctx))
+
+	var versions int
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM lkdr_store_migrations`).Scan(&versions))
+	assert.Equal(t, 10, versions)
+}
+
+func TestStore_Receipts(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStore(t)
+
+	date := func(day int) lkdr.DateTime {
+		return lkdr.DateTime(time.Date(2024, 5, day, 0, 0, 0, 0, time.UTC))
+	}
+
+	brandID := int64(1)
+	require.NoError(t, s.PutReceiptOut(ctx, &lkdr.ReceiptOut{
+		Brands: []lkdr.Brand{{Id: brandID, Name: "brand"}},
+		Receipts: []lkdr.Receipt{
+			{Key: "a", BrandId: &brandID, KktOwnerInn: "111", CreatedDate: date(1)},
+			{Key: "b", KktOwnerInn: "222", CreatedDate: date(2)},
+			{Key: "c", KktOwnerInn: "111", CreatedDate: date(3)},
+		},
+	}))
+
+	require.NoError(t, s.PutFiscalData(ctx, "a", &lkdr.FiscalDataOut{Items: []lkdr.FiscalDataItem{{Name: "milk 100%"}}}))
+	require.NoError(t, s.PutFiscalData(ctx, "b", &lkdr.FiscalDataOut{Items: []lkdr.FiscalDataItem{{Name: "milk 1000"}}}))
+	require.NoError(t, s.PutFiscalData(ctx, "c", &lkdr.FiscalDataOut{Items: []lkdr.FiscalDataItem{{Name: "bread_white"}}}))
+
+	for _, tc := range []struct {
+		name  string
+		query store.Query
+		keys  []string
+	}{
+		{"all", store.Query{}, []string{"c", "b", "a"}},
+		{"from is inclusive, to is exclusive", store.Query{From: date(2).Time(), To: date(3).Time()}, []string{"b"}},
+		{"inn", store.Query{Inn: "111"}, []string{"c", "a"}},
+		{"brand", store.Query{BrandID: &brandID}, []string{"a"}},
+		{"item name", store.Query{ItemName: "milk"}, []string{"b", "a"}},
+		{"item name with percent", store.Query{ItemName: "100%"}, []string{"a"}},
+		{"item name with underscore", store.Query{ItemName: "d_w"}, []string{"c"}},
+		{"item name underscore is not a wildcard", store.Query{ItemName: "k_1"}, []string{}},
+		{"limit and offset", store.Query{Limit: 1, Offset: 1}, []string{"b"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			receipts, err := s.Receipts(ctx, tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.keys, keys(receipts))
+		})
+	}
+
+	t.Run("brand is joined", func(t *testing.T) {
+		receipts, err := s.Receipts(ctx, store.Query{BrandID: &brandID})
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		require.NotNil(t, receipts[0].Brand)
+		assert.Equal(t, "brand", receipts[0].Brand.Name)
+	})
+}
+
+func TestStore_FiscalData(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStore(t)
+
+	t.Run("missing", func(t *testing.T) {
+		data, err := s.FiscalData(ctx, "missing")
+		require.NoError(t, err)
+		assert.Nil(t, data)
+	})
+
+	t.Run("upsert replaces items", func(t *testing.T) {
+		require.NoError(t, s.PutFiscalData(ctx, "key", &lkdr.FiscalDataOut{
+			TotalSum: 3,
+			Items:    []lkdr.FiscalDataItem{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+		}))
+
+		require.NoError(t, s.PutFiscalData(ctx, "key", &lkdr.FiscalDataOut{
+			TotalSum: 1,
+			Items:    []lkdr.FiscalDataItem{{Name: "d", ProviderData: &lkdr.ProviderData{ProviderName: "provider"}}},
+		}))
+
+		data, err := s.FiscalData(ctx, "key")
+		require.NoError(t, err)
+		require.NotNil(t, data)
+		assert.Equal(t, 1.0, data.TotalSum)
+		require.Len(t, data.Items, 1)
+		assert.Equal(t, "d", data.Items[0].Name)
+		require.NotNil(t, data.Items[0].ProviderData)
+		assert.Equal(t, "provider", data.Items[0].ProviderData.ProviderName)
+	})
+}
+
+func TestStore_Raw(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStore(t)
+
+	raw := json.RawMessage(`{"key":"key","totalSum":"1.00","unknown":true}`)
+	var receipt lkdr.Receipt
+	require.NoError(t, json.Unmarshal(raw, &receipt))
+	require.NoError(t, s.PutReceipts(ctx, receipt))
+
+	t.Run("is stored", func(t *testing.T) {
+		receipts, err := s.Receipts(ctx, store.Query{})
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		assert.JSONEq(t, string(raw), string(receipts[0].Raw))
+	})
+
+	t.Run("is kept on upsert without raw", func(t *testing.T) {
+		receipt.Raw = nil
+		receipt.TotalSum = "2.00"
+		require.NoError(t, s.PutReceipts(ctx, receipt))
+
+		receipts, err := s.Receipts(ctx, store.Query{})
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		assert.Equal(t, "2.00", receipts[0].TotalSum)
+		assert.JSONEq(t, string(raw), string(receipts[0].Raw))
+	})
+
+	t.Run("is replaced on upsert with raw", func(t *testing.T) {
+		raw := json.RawMessage(`{"key":"key","totalSum":"3.00"}`)
+		var receipt lkdr.Receipt
+		require.NoError(t, json.Unmarshal(raw, &receipt))
+		require.NoError(t, s.PutReceipts(ctx, receipt))
+
+		receipts, err := s.Receipts(ctx, store.Query{})
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		assert.JSONEq(t, string(raw), string(receipts[0].Raw))
+	})
+}
@@ -0,0 +1,143 @@
+package lkdr
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+// SyncState is a cursor pointing to the newest receipts delivered to SyncSink.
+// Keys are the keys of delivered receipts received at ReceiveDate, since several receipts may share it.
+type SyncState struct {
+	ReceiveDate DateTime `json:"receiveDate"`
+	Keys        []string `json:"keys"`
+}
+
+func (s *SyncState) delivered(receipt *ReceiptWithBrand) bool {
+	return receipt.ReceiveDate.Time().Equal(s.ReceiveDate.Time()) && slices.Contains(s.Keys, receipt.Key)
+}
+
+// advance returns the state after the receipt is delivered.
+func (s *SyncState) advance(receipt *ReceiptWithBrand) *SyncState {
+	if s == nil || !receipt.ReceiveDate.Time().Equal(s.ReceiveDate.Time()) {
+		return &SyncState{ReceiveDate: receipt.ReceiveDate, Keys: []string{receipt.Key}}
+	}
+
+	return &SyncState{ReceiveDate: s.ReceiveDate, Keys: append(slices.Clone(s.Keys), receipt.Key)}
+}
+
+// SyncStateStorage persists SyncState per phone.
+type SyncStateStorage interface {
+	LoadSyncState(ctx context.Context, phone string) (*SyncState, error)
+	UpdateSyncState(ctx context.Context, phone string, state *SyncState) error
+}
+
+// SyncedReceipt is a new receipt discovered during sync.
+// FiscalData is nil if it is not yet available (see IsDataNotFound).
+type SyncedReceipt struct {
+	ReceiptWithBrand
+	FiscalData *FiscalDataOut
+}
+
+func (r *SyncedReceipt) UnmarshalJSON(data []byte) error {
+	var wrapper struct{ FiscalData *FiscalDataOut }
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+
+	r.FiscalData = wrapper.FiscalData
+	return r.ReceiptWithBrand.UnmarshalJSON(data)
+}
+
+// SyncSink receives new receipts.
+// A receipt may be delivered again if sync is interrupted before the cursor is updated, so Consume should be idempotent.
+type SyncSink interface {
+	Consume(ctx context.Context, phone string, receipt *SyncedReceipt) error
+}
+
+type SyncerParams struct {
+	Client  *Client          `validate:"required"`
+	Storage SyncStateStorage `validate:"required"`
+	Sink    SyncSink         `validate:"required"`
+
+	// PageSize is the number of receipts requested per page.
+	PageSize int
+}
+
+// Syncer incrementally downloads receipts newer than the persisted cursor.
+type Syncer struct {
+	client   *Client
+	storage  SyncStateStorage
+	sink     SyncSink
+	pageSize int
+}
+
+func NewSyncer(params SyncerParams) (*Syncer, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	return &Syncer{
+		client:   params.Client,
+		storage:  params.Storage,
+		sink:     params.Sink,
+		pageSize: params.PageSize,
+	}, nil
+}
+
+// Sync pages through receipts from newest to oldest until it reaches receipts older than the cursor,
+// skipping the ones already delivered at the cursor date, then delivers new receipts to the sink from oldest to newest, advancing the cursor after each one.
+// This way an interrupted sync resumes from the last delivered receipt.
+// It returns the number of delivered receipts.
+func (s *Syncer) Sync(ctx context.Context) (int, error) {
+	phone := s.client.phone
+	state, err := s.storage.LoadSyncState(ctx, phone)
+	if err != nil {
+		return 0, errors.Wrap(err, "load sync state")
+	}
+
+	var receipts []ReceiptWithBrand
+	for receipt, err := range s.client.Receipts(ctx, &ReceiptIn{
+		Limit:   s.pageSize,
+		OrderBy: "RECEIVE_DATE:DESC",
+	}) {
+		if err != nil {
+			return 0, err
+		}
+
+		if state != nil {
+			if receipt.ReceiveDate.Time().Before(state.ReceiveDate.Time()) {
+				break
+			}
+
+			if state.delivered(&receipt) {
+				continue
+			}
+		}
+
+		receipts = append(receipts, receipt)
+	}
+
+	slices.Reverse(receipts)
+	for i, receipt := range receipts {
+		synced := &SyncedReceipt{ReceiptWithBrand: receipt}
+		synced.FiscalData, err = s.client.FiscalData(ctx, &FiscalDataIn{Key: receipt.Key})
+		if err != nil && !IsDataNotFound(err) {
+			return i, errors.Wrapf(err, "get fiscal data for %s", receipt.Key)
+		}
+
+		if err := s.sink.Consume(ctx, phone, synced); err != nil {
+			return i, errors.Wrapf(err, "consume %s", receipt.Key)
+		}
+
+		state = state.advance(&receipt)
+		if err := s.storage.UpdateSyncState(ctx, phone, state); err != nil {
+			return i + 1, errors.Wrap(err, "update sync state")
+		}
+	}
+
+	return len(receipts), nil
+}
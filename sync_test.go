@@ -0,0 +1,112 @@
+package lkdr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jfk9w-go/lkdr-api"
+	"github.com/jfk9w-go/lkdr-api/lkdrtest"
+)
+
+type syncStateStorage struct {
+	state *lkdr.SyncState
+}
+
+func (s *syncStateStorage) LoadSyncState(context.Context, string) (*lkdr.SyncState, error) {
+	return s.state, nil
+}
+
+func (s *syncStateStorage) UpdateSyncState(_ context.Context, _ string, state *lkdr.SyncState) error {
+	s.state = state
+	return nil
+}
+
+// syncSink collects delivered receipts. It fails once after failAfter receipts if failAfter is positive.
+type syncSink struct {
+	receipts  []*lkdr.SyncedReceipt
+	failAfter int
+}
+
+func (s *syncSink) Consume(_ context.Context, _ string, receipt *lkdr.SyncedReceipt) error {
+	if s.failAfter > 0 && len(s.receipts) == s.failAfter {
+		s.failAfter = 0
+		return errors.New("sink is unavailable")
+	}
+
+	s.receipts = append(s.receipts, receipt)
+	return nil
+}
+
+func (s *syncSink) keys() []string {
+	keys := make([]string, len(s.receipts))
+	for i, receipt := range s.receipts {
+		keys[i] = receipt.Key
+	}
+
+	return keys
+}
+
+func TestSyncer(t *testing.T) {
+	ctx := context.Background()
+	server := lkdrtest.NewServer(lkdrtest.ServerParams{})
+	defer server.Close()
+
+	storage := newMemoryStorage()
+	require.NoError(t, storage.UpdateTokens(ctx, phone, server.IssueTokens(phone)))
+	client := newClient(t, server, storage, nil)
+
+	date := time.Now().Truncate(time.Second)
+	receipt := func(key string, minutes int) lkdr.Receipt {
+		return lkdr.Receipt{Key: key, ReceiveDate: lkdr.DateTime(date.Add(time.Duration(minutes) * time.Minute))}
+	}
+
+	server.AddReceipts(phone, receipt("a", 0), receipt("b", 1), receipt("c", 2), receipt("d", 2))
+	server.SetFiscalData("b", &lkdr.FiscalDataOut{TotalSum: 10})
+
+	state := new(syncStateStorage)
+	sink := &syncSink{failAfter: 2}
+	syncer, err := lkdr.NewSyncer(lkdr.SyncerParams{
+		Client:   client,
+		Storage:  state,
+		Sink:     sink,
+		PageSize: 2,
+	})
+
+	require.NoError(t, err)
+
+	t.Run("resumes after failure", func(t *testing.T) {
+		delivered, err := syncer.Sync(ctx)
+		assert.Error(t, err)
+		assert.Equal(t, 2, delivered)
+		assert.Equal(t, []string{"a", "b"}, sink.keys())
+		assert.Nil(t, sink.receipts[0].FiscalData)
+		require.NotNil(t, sink.receipts[1].FiscalData)
+		assert.Equal(t, 10.0, sink.receipts[1].FiscalData.TotalSum)
+
+		delivered, err = syncer.Sync(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, delivered)
+		assert.ElementsMatch(t, []string{"c", "d"}, sink.keys()[2:])
+		assert.ElementsMatch(t, []string{"c", "d"}, state.state.Keys)
+	})
+
+	t.Run("delivers nothing without new receipts", func(t *testing.T) {
+		delivered, err := syncer.Sync(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, delivered)
+	})
+
+	t.Run("delivers new receipts sharing the cursor date", func(t *testing.T) {
+		server.AddReceipts(phone, receipt("e", 2), receipt("f", 3))
+		delivered, err := syncer.Sync(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, delivered)
+		assert.Equal(t, []string{"e", "f"}, sink.keys()[4:])
+		assert.Equal(t, []string{"f"}, state.state.Keys)
+	})
+}